@@ -0,0 +1,71 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The backoff portion of the graphd package computes the exponential backoff delays used by the
+// background reconnector.
+
+package graphd
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Default backoff parameters, overridable via WithInitialBackoff, WithMaxBackoff and
+// WithBackoffJitter.
+const (
+	defaultInitialBackoff = 1 * time.Second
+	defaultMaxBackoff     = 120 * time.Second
+	defaultBackoffJitter  = 0.2
+
+	// backoffMultiplier is how much the delay grows per failed attempt.
+	backoffMultiplier = 1.6
+)
+
+// backoffConfig holds the exponential-backoff parameters used by a connection's reconnector.
+type backoffConfig struct {
+	initial time.Duration
+	max     time.Duration
+	jitter  float64
+}
+
+// defaultBackoffConfig returns the backoffConfig a graphd instance is constructed with absent
+// any WithInitialBackoff/WithMaxBackoff/WithBackoffJitter options.
+func defaultBackoffConfig() backoffConfig {
+	return backoffConfig{
+		initial: defaultInitialBackoff,
+		max:     defaultMaxBackoff,
+		jitter:  defaultBackoffJitter,
+	}
+}
+
+// next returns the delay to wait before the (attempt+1)-th reconnect attempt; attempt is 0-based,
+// so next(0) returns cfg.initial (plus jitter).  The delay grows by backoffMultiplier per prior
+// attempt, capped at cfg.max, and is then perturbed by up to +/- cfg.jitter as a fraction of
+// itself so that many connections backing off in lockstep don't retry in lockstep too.
+func (cfg backoffConfig) next(attempt int) time.Duration {
+	d := float64(cfg.initial)
+	for i := 0; i < attempt; i++ {
+		d *= backoffMultiplier
+		if d >= float64(cfg.max) {
+			d = float64(cfg.max)
+			break
+		}
+	}
+
+	if cfg.jitter > 0 {
+		d += d * cfg.jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
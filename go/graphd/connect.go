@@ -14,24 +14,133 @@
 package graphd
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// A lockable net.Conn.
+// A lockable net.Conn.  Beyond the lock inherited from sync.Mutex (held across Dial/Disconnect),
+// connection also supports pipelining: writeMu serializes only the write phase of a Query, while
+// turnMu/turnCond hand read access to the shared reader to callers strictly in the order their
+// writes hit the wire, so one slow reader cannot hold up other callers' writes.
 type connection struct {
 	sync.Mutex
 	netConn net.Conn
+	reader  *bufio.Reader
+
+	writeMu sync.Mutex
+
+	turnMu     sync.Mutex
+	turnCond   *sync.Cond
+	generation uint64 // bumped by resetPipeline each time netConn is replaced
+	turn       uint64 // ticket number of the caller allowed to read next
+	nextTurn   uint64 // ticket number that will be handed out next
+	closed     bool   // set when the connection has been torn down while callers awaited their turn
+
+	stateMu          sync.Mutex
+	stateCond        *sync.Cond
+	state            ConnState
+	reconnectRunning bool // whether a reconnectLoop goroutine is already active for this connection
+
+	inFlight atomic.Int32 // number of requests currently dispatched to this connection
 }
 
 // primeConnection prepares and returns a connection structure pointer.
 func primeConnection() *connection {
-	conn := connection{}
-	return &conn
+	c := &connection{}
+	c.turnCond = sync.NewCond(&c.turnMu)
+	c.stateCond = sync.NewCond(&c.stateMu)
+	return c
+}
+
+// State returns c's current ConnState.
+func (c *connection) State() ConnState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// setState transitions c to s, waking any WaitForStateChange callers if it actually changed.
+func (c *connection) setState(s ConnState) {
+	c.stateMu.Lock()
+	if c.state != s {
+		c.state = s
+		c.stateCond.Broadcast()
+	}
+	c.stateMu.Unlock()
+}
+
+// WaitForStateChange blocks until c's state differs from current, or ctx is done, and reports
+// which happened first: true if the state changed, false if ctx ended the wait.
+func (c *connection) WaitForStateChange(ctx context.Context, current ConnState) bool {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.stateMu.Lock()
+			c.stateCond.Broadcast()
+			c.stateMu.Unlock()
+		case <-done:
+		}
+	}()
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	for c.state == current {
+		if ctx.Err() != nil {
+			return false
+		}
+		c.stateCond.Wait()
+	}
+	return ctx.Err() == nil
+}
+
+// awaitState blocks until c reaches target, returning true, or is shut down, returning false.
+func (c *connection) awaitState(target ConnState) bool {
+	for {
+		cur := c.State()
+		if cur == target {
+			return true
+		}
+		if cur == StateShutdown {
+			return false
+		}
+		c.WaitForStateChange(context.Background(), cur)
+	}
+}
+
+// InFlight returns the number of requests currently dispatched to c and awaiting a reply.  A
+// Pool consults this to dispatch to the least-loaded member connection.
+func (c *connection) InFlight() int32 {
+	return c.inFlight.Load()
+}
+
+// ensureReconnectLoop starts g's background reconnector (reconnectLoop) for c, unless one is
+// already running.
+func (c *connection) ensureReconnectLoop(g *graphd) {
+	c.stateMu.Lock()
+	if c.reconnectRunning {
+		c.stateMu.Unlock()
+		return
+	}
+	c.reconnectRunning = true
+	c.stateMu.Unlock()
+
+	go func() {
+		g.reconnectLoop(c)
+		c.stateMu.Lock()
+		c.reconnectRunning = false
+		c.stateMu.Unlock()
+	}()
 }
 
 // exists only checks if this graphd instance has a non-nil connection (net.Conn) with a graph
@@ -41,148 +150,357 @@ func (c *connection) exists() bool {
 	return c.netConn != nil
 }
 
-// Connections are sent/received on res.  awaitingConn is used as a mutex.
-type connChan struct {
-	res          chan net.Conn
-	awaitingConn chan int
+// currentNetConn returns c's netConn under the connection lock, so callers outside of
+// Dial/Disconnect (which already hold it for the whole operation) can read it without racing
+// disconnectConnection's teardown.  It returns nil if no connection is present.
+func (c *connection) currentNetConn() net.Conn {
+	c.Lock()
+	defer c.Unlock()
+	return c.netConn
 }
 
-// Returns a ready to use connCh struct.
-func initConnCh() connChan {
-	connCh := connChan{}
-	connCh.res = make(chan net.Conn)
-	connCh.awaitingConn = make(chan int)
-	return connCh
+// readTicket identifies a caller's place in the read queue for one connection generation, the
+// span between two resetPipeline calls.  A ticket handed out before a reconnect is only ever
+// valid for the generation it was taken in: awaitTurn treats a ticket whose generation no longer
+// matches c.generation as if the connection had been closed out from under it, rather than
+// letting it resume reading the new connection's reply stream at the old ticket's turn number.
+type readTicket struct {
+	gen  uint64
+	turn uint64
 }
 
-// dial attempts to acquire a connection to url with the specified timeout.  On connection
-// success or failure, dial will attempt to send the net.Conn over the result channel if it
-// can read from the awaitingConn channel (used as mutex here).  If the read fails (awaitingConn
-// has been closed by the caller), dial will attempt to close the acquired connection.
-func (g *graphd) dial(url *url.URL, timeout time.Duration, connCh connChan) {
-	// A timeout of zero value is equivalent to no timeout.
-	dialer := net.Dialer{Timeout: timeout}
+// resetPipeline (re)initializes the reader and turn-ticketing state for a freshly dialed
+// netConn.  Must be called with the connection lock held.
+func (c *connection) resetPipeline(netConn net.Conn) {
+	c.netConn = netConn
+	c.reader = bufio.NewReader(netConn)
+
+	c.turnMu.Lock()
+	c.generation++
+	c.turn = 0
+	c.nextTurn = 0
+	c.closed = false
+	c.turnCond.Broadcast() // wake any waiter stuck on a now-stale generation's ticket
+	c.turnMu.Unlock()
+}
 
-	// Attempt to dial.  Log error, but send conn anyway.  A nil conn will be discarded by the
-	// caller.
-	g.LogDebugf("dialing %v with timeout %v", url, timeout)
-	conn, err := dialer.Dial(url.Scheme, url.Host)
-	if err != nil {
-		g.LogErrf("failed to dial %v: %v", url, err)
+// takeTurnLocked assigns the calling goroutine the next read ticket of the current generation.
+// It must be called with writeMu held, immediately after a successful write, so tickets are
+// handed out in exactly the order requests hit the wire.
+func (c *connection) takeTurnLocked() readTicket {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	t := readTicket{gen: c.generation, turn: c.nextTurn}
+	c.nextTurn++
+	return t
+}
+
+// awaitTurn blocks until ticket is next in line to read, or the connection is closed out from
+// under it, or resetPipeline moves it to a new generation, in which case it returns false.
+func (c *connection) awaitTurn(ticket readTicket) bool {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	for c.generation == ticket.gen && c.turn != ticket.turn && !c.closed {
+		c.turnCond.Wait()
 	}
+	return c.generation == ticket.gen && !c.closed
+}
 
-	// If an acquired connection has not yet been sent by a dilaer, go ahead and send the conn,
-	// regardless if it's nil, and return.
-	if _, ok := <-connCh.awaitingConn; ok {
-		if conn != nil {
-			g.LogDebugf("sending acquired connection to %v on channel", url)
-		} else {
-			g.LogDebugf("sending nil connection to %v on channel", url)
-		}
-		connCh.res <- conn
-		return
+// finishTurn advances the read ticket and wakes any callers awaiting their turn.
+func (c *connection) finishTurn() {
+	c.turnMu.Lock()
+	c.turn++
+	c.turnCond.Broadcast()
+	c.turnMu.Unlock()
+}
+
+// closeForCancel tears down the connection out from under any readers awaiting their turn,
+// unblocking a goroutine parked in a read on netConn.  Used when a caller's context is cancelled
+// mid-read.
+func (c *connection) closeForCancel() {
+	c.turnMu.Lock()
+	c.closed = true
+	c.turnCond.Broadcast()
+	c.turnMu.Unlock()
+
+	if netConn := c.currentNetConn(); netConn != nil {
+		netConn.Close()
 	}
+}
 
-	// A connection was already acquired by another dialer.  If we did acquire a connection,
-	// close it.
-	if conn != nil {
-		g.LogDebugf("discarding and closing acquired connection to %v", url)
-		if err := conn.Close(); err != nil {
-			g.LogErrf("failed to close acquired connection to %v, resource leak", url)
-		}
+// ctxFromTimeout returns a Context with a timeout of t seconds and its accompanying cancel func,
+// or context.Background() (paired with a no-op cancel) if t <= 0, meaning no timeout.  It exists
+// so the legacy int-seconds Dial/Redial can be implemented as thin wrappers over their *Context
+// counterparts.
+func ctxFromTimeout(t int) (context.Context, context.CancelFunc) {
+	if t <= 0 {
+		return context.Background(), func() {}
 	}
+	return context.WithTimeout(context.Background(), time.Duration(t)*time.Second)
 }
 
-// Dial a graphd database.  Dial will attempt to connect to all URLs found in the URLs list associated
-// with this graphd instance, retaining the first successful connection.  On failure, an appropriate
-// error code is returned.  If an acquired connection is already present and valid, Dial returns nil.
-// Timeout is specified in seconds.  A timeout of 0 is treated as no timeout.
-// Dial ensures only one thread is dialing at a time.
-func (g *graphd) Dial(t int) error {
-	// Set timeout if t > 0, otherwise use the zero value (0s) which signals no timeout.
-	var timeout time.Duration
-	if t > 0 {
-		timeout = time.Duration(t) * time.Second
+// defaultDialFallbackDelay is how long dialConnectionContext waits after starting a URL before
+// falling back to the next one, absent a WithDialFallbackDelay override.
+const defaultDialFallbackDelay = 300 * time.Millisecond
+
+// dialResult is one per-URL outcome fed back to dialConnectionContext's winner-selection loop.
+type dialResult struct {
+	url  *url.URL
+	conn net.Conn
+	err  error
+}
+
+// dialOrder returns the URLs to attempt, in the order dialConnectionContext should start them:
+// g.urls as given to New, or a per-call shuffled copy if WithURLShuffle is set.
+func (g *graphd) dialOrder() []*url.URL {
+	order := append([]*url.URL(nil), g.urls...)
+	if g.urlShuffle {
+		rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+	return order
+}
+
+// dialOne attempts to acquire a connection to url, honoring ctx for cancellation/deadlines, and
+// performing a TLS handshake (also bound by ctx) if url's scheme calls for one.
+func (g *graphd) dialOne(ctx context.Context, url *url.URL) (net.Conn, error) {
+	g.LogDebugKV("dialing", "url", url)
+	return dialURL(ctx, url, g.tlsConfig)
+}
+
+// dialURL is dialOne's dial+handshake logic, extracted as a free function so a ReverseDialer can
+// reach a coordinator URL without needing a full graphd instance to hang it off of.
+func dialURL(ctx context.Context, url *url.URL, baseTLSConfig *tls.Config) (net.Conn, error) {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, networkForScheme(url.Scheme), addrForURL(url))
+	if err != nil {
+		return nil, err
+	}
+	if !isTLSScheme(url.Scheme) {
+		return conn, nil
 	}
 
-	// If URLs list is empty, return error.
-	if len(g.urls) == 0 {
+	tlsConfig, err := tlsConfigForURL(url, baseTLSConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// Dial a graphd database.  Dial fans out across every connection in g's pool (one by default, or
+// WithPoolSize(n) of them), each independently attempting every URL found in the URLs list
+// associated with this graphd instance and retaining its first successful connection.  An
+// already-connected pool member is left untouched.  Timeout is specified in seconds; a timeout of
+// 0 is treated as no timeout.  Dial returns the last encountered error, if any, but always
+// attempts every pool member regardless of earlier failures.
+func (g *graphd) Dial(t int) error {
+	return g.pool.Dial(t)
+}
+
+// DialContext is Dial, but takes a ctx instead of an integer timeout so a caller can cancel an
+// in-progress dial or propagate an upstream deadline.  If ctx is done before a pool member is
+// connected, that member's dial aborts every outstanding per-URL dialer and reports ctx.Err().
+func (g *graphd) DialContext(ctx context.Context) error {
+	return g.pool.DialContext(ctx)
+}
+
+// dialConnection is the per-connection Dial implementation, parameterized over the connection to
+// dial into so that a Pool can dial each of its member connections with the same logic.
+func (g *graphd) dialConnection(c *connection, t int) error {
+	ctx, cancel := ctxFromTimeout(t)
+	defer cancel()
+	return g.dialConnectionContext(ctx, c)
+}
+
+// dialConnectionContext is the per-connection DialContext implementation, parameterized over the
+// connection to dial into so that a Pool can dial each of its member connections with the same
+// logic.  URLs are started in staggered, RFC 8305 "Happy Eyeballs" fashion: the first URL starts
+// immediately, and each subsequent URL starts after g.dialFallbackDelay unless an earlier attempt
+// has already failed, in which case the next URL starts right away.  As soon as one attempt
+// succeeds, the shared child context is cancelled so every other in-flight or not-yet-started
+// dialer aborts promptly instead of finishing (or starting) only to have its result discarded.
+func (g *graphd) dialConnectionContext(ctx context.Context, c *connection) error {
+	// If neither a reverse dialer nor any URL is configured, return error.
+	if g.reverseDialer == nil && len(g.urls) == 0 {
 		errStr := fmt.Sprintf("no URL found in %v", g.urls)
-		g.LogErrf(errStr)
+		g.LogErr(errStr)
 		return errors.New(errStr)
 	}
 
 	// Only one thread dialing at a time.
-	g.conn.Lock()
-	defer g.conn.Unlock()
+	c.Lock()
+	defer c.Unlock()
 
 	// If already connected, return success.
-	if g.conn.exists() {
-		g.LogDebugf("already connected to %v", g.conn.netConn.RemoteAddr())
+	if c.exists() {
+		g.LogDebugKV("already connected", "remote", c.netConn.RemoteAddr())
 		return nil
 	}
 
-	// In parallel, for each URL in the list, send a connection request along with the timeout.
-	g.LogDebugf("attempting to connect to %v", g.urls)
-	connCh := initConnCh()
-	numDialers := 0
-	for _, url := range g.urls {
-		go g.dial(url, timeout, connCh)
-		numDialers++
-	}
+	c.ensureReconnectLoop(g)
+	c.setState(StateConnecting)
 
-	// Loop and wait for connections from dialers.  Send an int to signal we're waiting for a connection.
-	// Once we've acquired a valid connection, close our signal channel, preventing other dialers from
-	// sending further connections, and return success.
-	for numDialers > 0 {
-		connCh.awaitingConn <- 1
-		conn := <-connCh.res
-		numDialers--
+	// WithReverseDial replaces direct dialing of g.urls with accepting the next logical
+	// connection the coordinator multiplexes back through the shared reverse-dial tunnel.
+	if g.reverseDialer != nil {
+		return g.dialReverseLocked(ctx, c)
+	}
 
-		// If the connection is invalid, continue listening.
-		if conn == nil {
-			continue
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	order := g.dialOrder()
+
+	// Start each URL's dialer under dialCtx, staggered by g.dialFallbackDelay: start url[0]
+	// immediately, then wait up to the fallback delay before starting url[1], and so on, unless a
+	// prior attempt fails first, in which case the wait is cut short and the next URL starts
+	// right away.  Each dialer either delivers its result on results, or -- if dialCtx is
+	// cancelled first, because another dialer already won or the caller's ctx gave up -- closes
+	// any connection it acquired itself and exits.
+	g.LogDebugKV("attempting to connect", "urls", order)
+	results := make(chan dialResult, len(order))
+	failed := make(chan struct{}, len(order))
+	go func() {
+		for i, url := range order {
+			url := url
+			if i > 0 {
+				timer := time.NewTimer(g.dialFallbackDelay)
+				select {
+				case <-timer.C:
+				case <-failed:
+					timer.Stop()
+				case <-dialCtx.Done():
+					timer.Stop()
+					return
+				}
+			}
+			go func() {
+				conn, err := g.dialOne(dialCtx, url)
+				if err != nil {
+					select {
+					case failed <- struct{}{}:
+					default:
+					}
+				}
+				select {
+				case results <- dialResult{url, conn, err}:
+				case <-dialCtx.Done():
+					if conn != nil {
+						g.LogDebugKV("discarding and closing acquired connection", "url", url, "remote", conn.RemoteAddr())
+						conn.Close()
+					}
+				}
+			}()
+		}
+	}()
+
+	var lastErr error
+	for i := 0; i < len(order); i++ {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				g.LogErrKV("failed to dial", "url", res.url, "error", res.err)
+				lastErr = res.err
+				continue
+			}
+
+			// Acquired a valid connection.  Set connection instance and reset pipelining state
+			// for it, then cancel dialCtx so any other in-flight dialers abort.
+			g.LogDebugKV("successfully connected", "remote", res.conn.RemoteAddr())
+			c.resetPipeline(res.conn)
+			c.setState(StateReady)
+			return nil
+
+		case <-ctx.Done():
+			c.setState(StateTransientFailure)
+			return ctx.Err()
 		}
-
-		// Acquired a valid connection.  Set connection instance.
-		g.LogDebugf("successfully connected to %v", conn.RemoteAddr())
-		g.conn.netConn = conn
-
-		// Signal dialers that we've acquired a connection.
-		close(connCh.awaitingConn)
-
-		// Return success.
-		return nil
 	}
 
 	// If no valid connection is acquired, return error
 	errStr := fmt.Sprintf("failed to connect to any URL in %v", g.urls)
+	if lastErr != nil {
+		errStr = fmt.Sprintf("%s: %v", errStr, lastErr)
+	}
 	g.LogErr(errStr)
+	c.setState(StateTransientFailure)
 	return errors.New(errStr)
 }
 
-// Disconnect attempts to close the existing connection to a graphd database.  On success, nil is
-// returned.  On failure, an error is returned.  Regardless if the connection was properly closed,
-// the connection is zeroed out.
+// dialReverseLocked connects (or reuses) g.reverseDialer's shared control connection, then blocks
+// for the coordinator to open the next logical stream and adopts it as c's netConn.  Must be
+// called with c's lock held.
+func (g *graphd) dialReverseLocked(ctx context.Context, c *connection) error {
+	if err := g.reverseDialer.ensureConnected(ctx); err != nil {
+		c.setState(StateTransientFailure)
+		return err
+	}
+
+	conn, err := g.reverseDialer.Accept(ctx)
+	if err != nil {
+		c.setState(StateTransientFailure)
+		return err
+	}
+
+	g.LogDebugKV("accepted reverse-dial stream", "remote", conn.RemoteAddr())
+	c.resetPipeline(conn)
+	c.setState(StateReady)
+	return nil
+}
+
+// Disconnect closes every connection in g's pool, and -- if WithReverseDial configured one -- the
+// shared control connection to the coordinator.  It returns the last encountered error, if any,
+// but attempts every teardown regardless of earlier failures.
 func (g *graphd) Disconnect() error {
+	err := g.pool.Disconnect()
+	if g.reverseDialer != nil {
+		if closeErr := g.reverseDialer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// disconnectConnection is the per-connection Disconnect implementation, parameterized over the
+// connection to tear down so that a Pool can retire each of its member connections with the same
+// logic.
+func (g *graphd) disconnectConnection(c *connection) error {
 	// Only one thread at a time allowed to Disconnect.
-	g.conn.Lock()
-	defer g.conn.Unlock()
+	c.Lock()
+	defer c.Unlock()
 
 	// If not connected, return success.
-	if !g.conn.exists() {
+	if !c.exists() {
 		g.LogDebug("no connection present")
+		// Still mark it shut down, so a reconnector left running by an earlier failed dial or
+		// write exits instead of redialing it.
+		c.setState(StateShutdown)
 		return nil
 	}
 
-	// Zero out the connection on function exit.
-	defer func() { g.conn.netConn = nil }()
+	// Zero out the connection on function exit, release any callers still awaiting their turn to
+	// read a pipelined response, and mark the connection shut down so the background reconnector
+	// (if running) exits instead of redialing it.
+	defer func() {
+		c.netConn = nil
+		c.turnMu.Lock()
+		c.closed = true
+		c.turnCond.Broadcast()
+		c.turnMu.Unlock()
+		c.setState(StateShutdown)
+	}()
 
 	// Retain address for logs.
-	connectedToAddr := g.conn.netConn.RemoteAddr()
+	connectedToAddr := c.netConn.RemoteAddr()
 
 	// Try to close the existing connection.
-	err := g.conn.netConn.Close()
+	err := c.netConn.Close()
 	if err != nil {
 		errStr := fmt.Sprintf("failed to close existing connection, resource leak: %v", err)
 		g.LogErr(errStr)
@@ -190,18 +508,38 @@ func (g *graphd) Disconnect() error {
 	}
 
 	// Return success.
-	g.LogDebugf("successfully disconnected from %v", connectedToAddr)
+	g.LogDebugKV("successfully disconnected", "remote", connectedToAddr)
 	return nil
 }
 
-// Redial first disconnects the existing connection, and calls Dial with the user provided
-// timeout (in seconds).  Redial returns the error code returned by Dial.
+// Redial disconnects and redials every connection in g's pool, fanning out across all members the
+// same way Dial and Disconnect do.  Redial returns the last encountered error, if any.
 func (g *graphd) Redial(t int) error {
+	return g.pool.Redial(t)
+}
+
+// RedialContext is Redial, but takes a ctx instead of an integer timeout; see DialContext.
+func (g *graphd) RedialContext(ctx context.Context) error {
+	return g.pool.RedialContext(ctx)
+}
+
+// redialConnection is the per-connection Redial implementation, parameterized over the connection
+// to redial so that a Pool can recover each of its member connections with the same logic.
+func (g *graphd) redialConnection(c *connection, t int) error {
+	ctx, cancel := ctxFromTimeout(t)
+	defer cancel()
+	return g.redialConnectionContext(ctx, c)
+}
+
+// redialConnectionContext is the per-connection RedialContext implementation, parameterized over
+// the connection to redial so that a Pool can recover each of its member connections with the
+// same logic.
+func (g *graphd) redialConnectionContext(ctx context.Context, c *connection) error {
 	// Try to disconnect.  Continue with redial despite any failure.
-	g.Disconnect()
+	g.disconnectConnection(c)
 
 	// Dial.
-	if err := g.Dial(t); err != nil {
+	if err := g.dialConnectionContext(ctx, c); err != nil {
 		errStr := fmt.Sprintf("failed to reconnect: %v", err)
 		g.LogErr(errStr)
 		return errors.New(errStr)
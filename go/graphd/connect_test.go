@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestAwaitTurnRejectsStaleGeneration guards against a caller parked in awaitTurn on a ticket
+// from before a reconnect getting woken by turn advancement on the new connection's generation --
+// it must instead see its wait end as if the connection had been closed, not read the new
+// connection's reply stream at the old ticket's turn number.
+func TestAwaitTurnRejectsStaleGeneration(t *testing.T) {
+	c := primeConnection()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c.resetPipeline(a)
+
+	c.writeMu.Lock()
+	c.takeTurnLocked() // ticket 0, occupies the head of the queue so ticket 1 below has to wait
+	stale := c.takeTurnLocked()
+	c.writeMu.Unlock()
+
+	done := make(chan bool, 1)
+	go func() { done <- c.awaitTurn(stale) }()
+
+	time.Sleep(10 * time.Millisecond)
+	c.Lock()
+	c.resetPipeline(b)
+	c.Unlock()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("awaitTurn(stale ticket) = true after resetPipeline bumped the generation, want false")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("awaitTurn(stale ticket) never returned after a generation change")
+	}
+}
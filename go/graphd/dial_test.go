@@ -0,0 +1,140 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"context"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var dialTestLogger = log.New(os.Stderr, "", 0)
+
+// unreachableAddr returns a loopback address nothing is listening on, so a dial to it fails fast
+// with "connection refused" rather than timing out.
+func unreachableAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve addr: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // closed immediately: nothing listens here, so dials fail fast with connection refused
+	return addr
+}
+
+func TestDialFallsBackImmediatelyOnFastFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	dead := unreachableAddr(t) // refuses immediately
+	good := ln.Addr().String()
+
+	g := New(dialTestLogger, syslog.LOG_DEBUG, []string{"tcp://" + dead, "tcp://" + good},
+		WithDialFallbackDelay(2*time.Second))
+
+	start := time.Now()
+	if err := g.Dial(5); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer g.Disconnect()
+	elapsed := time.Since(start)
+
+	if elapsed >= 2*time.Second {
+		t.Errorf("Dial took %v, want well under the 2s fallback delay since the first URL fails fast", elapsed)
+	}
+}
+
+// TestDialContextAbortsMidDialOnCancel guards the "all outstanding dialers abort when ctx.Done()
+// fires" behavior documented on DialContext: it dials a TLS URL whose server accepts the TCP
+// connection but never writes a byte, so the client is left blocked inside the TLS handshake
+// (not refused, not timed out by the network) until ctx is cancelled out from under it.
+func TestDialContextAbortsMidDialOnCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close() // accepted but never handshaked: the client's dial hangs here
+		}
+	}()
+
+	g := New(dialTestLogger, syslog.LOG_DEBUG,
+		[]string{"graphd+tls://" + ln.Addr().String() + "?insecureSkipVerify=1"})
+	defer g.Disconnect() // stop the background reconnector dialConnectionContext starts on failure
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = g.DialContext(ctx)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("DialContext(cancelled mid-handshake) = nil error, want one reporting the cancellation")
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("DialContext(cancelled mid-handshake) took %v to return, want well under 2s", elapsed)
+	}
+}
+
+func TestDialOrderShuffled(t *testing.T) {
+	g := New(dialTestLogger, syslog.LOG_DEBUG, []string{"tcp://a:1", "tcp://b:2", "tcp://c:3", "tcp://d:4", "tcp://e:5"},
+		WithURLShuffle())
+
+	same := true
+	for i := 0; i < 20; i++ {
+		order := g.dialOrder()
+		if order[0].Host != g.urls[0].Host {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Errorf("dialOrder() never reordered urls across 20 calls with WithURLShuffle set")
+	}
+}
+
+func TestDialOrderStableWithoutShuffle(t *testing.T) {
+	g := New(dialTestLogger, syslog.LOG_DEBUG, []string{"tcp://a:1", "tcp://b:2", "tcp://c:3"})
+
+	for i := 0; i < 5; i++ {
+		order := g.dialOrder()
+		for j, u := range order {
+			if u.Host != g.urls[j].Host {
+				t.Errorf("dialOrder() call %d: order[%d] = %v, want %v (stable order without WithURLShuffle)", i, j, u.Host, g.urls[j].Host)
+			}
+		}
+	}
+}
@@ -14,16 +14,102 @@
 package graphd
 
 import (
+	"crypto/tls"
 	"log/syslog"
-	"net"
 	"net/url"
+	"time"
 )
 
 // The interface to a running graphdb instance.
 type graphd struct {
-	logger *graphdLogger
-	urls   []*url.URL // URLS to connect to.
-	conn   net.Conn   // Acquired connection.
+	logger            *graphdLogger
+	urls              []*url.URL     // URLS to connect to.
+	pool              *Pool          // Pool of connections; one member unless WithPoolSize is used.
+	poolSize          int            // Number of connections pool should hold; see WithPoolSize.
+	tlsConfig         *tls.Config    // Base TLS config used to dial tls:// and unix+tls:// URLs, if set.
+	backoff           backoffConfig  // Backoff parameters used by the background reconnector.
+	dialFallbackDelay time.Duration  // Delay before falling back to the next URL; see WithDialFallbackDelay.
+	urlShuffle        bool           // Whether to randomize URL dial order per Dial; see WithURLShuffle.
+	reverseDialer     *ReverseDialer // If set by WithReverseDial, Dial accepts tunneled streams instead of dialing urls.
+}
+
+// Option configures optional behavior on a graphd instance.  Options are applied, in order, after
+// urlStrs have been parsed into g.urls.
+type Option func(*graphd)
+
+// WithTLSConfig sets the base *tls.Config used when dialing a tls:// or unix+tls:// URL.  Any
+// ?servername=, ?insecureSkipVerify=1 or ?ca=/path/to/ca.pem query parameters present on the URL
+// itself are layered on top of cfg at dial time; see tlsConfigForURL.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(g *graphd) {
+		g.tlsConfig = cfg
+	}
+}
+
+// WithInitialBackoff overrides the default 1s delay before the background reconnector's first
+// retry of a failed connection.
+func WithInitialBackoff(d time.Duration) Option {
+	return func(g *graphd) {
+		g.backoff.initial = d
+	}
+}
+
+// WithMaxBackoff overrides the default 120s cap the background reconnector's delay grows to
+// after repeated failures.
+func WithMaxBackoff(d time.Duration) Option {
+	return func(g *graphd) {
+		g.backoff.max = d
+	}
+}
+
+// WithBackoffJitter overrides the default +/- 0.2 (20%) fractional jitter applied to the
+// background reconnector's backoff delay, so that many connections backing off at once don't
+// retry in lockstep.
+func WithBackoffJitter(jitter float64) Option {
+	return func(g *graphd) {
+		g.backoff.jitter = jitter
+	}
+}
+
+// WithPoolSize configures g to maintain n independent connections instead of the default one,
+// dispatching each Query/QueryContext call to whichever pool member currently has the fewest
+// in-flight requests.  n <= 0 is ignored, leaving the pool at its default size of 1.
+func WithPoolSize(n int) Option {
+	return func(g *graphd) {
+		if n > 0 {
+			g.poolSize = n
+		}
+	}
+}
+
+// WithDialFallbackDelay overrides the default 300ms delay a Dial/DialContext attempt waits after
+// starting one URL before starting the next, RFC 8305 "Happy Eyeballs" style.  The wait is cut
+// short, and the next URL started immediately, if the in-flight attempt fails first.
+func WithDialFallbackDelay(d time.Duration) Option {
+	return func(g *graphd) {
+		g.dialFallbackDelay = d
+	}
+}
+
+// WithURLShuffle randomizes the order g's URLs are attempted in on each Dial/DialContext call,
+// instead of always starting with the first URL passed to New.  Use this to spread a reconnect
+// storm's first attempts across every configured URL rather than concentrating them on one.
+func WithURLShuffle() Option {
+	return func(g *graphd) {
+		g.urlShuffle = true
+	}
+}
+
+// WithReverseDial configures g for NAT-traversal deployments: instead of Dial/DialContext dialing
+// g.urls directly, g opens a single outbound control connection to coordinatorURL, identifies
+// itself as dialerID, and accepts logical connections the coordinator multiplexes back through
+// that tunnel -- one per pool member, via the shared ReverseDialer this creates.  Redial and
+// Disconnect operate on the same tunnel rather than g.urls.  See ReverseDialer and ReverseListener
+// for the wire protocol this relies on.
+func WithReverseDial(coordinatorURL, dialerID string) Option {
+	return func(g *graphd) {
+		g.reverseDialer = NewReverseDialer(coordinatorURL, dialerID)
+	}
 }
 
 // New returns a populated graphdb struct pointer.
@@ -31,8 +117,8 @@ type graphd struct {
 // argument will default to using syslog.
 // logLevel is used to control which log messages are emitted.
 // urlStrs is a list of URLs to which to try to connect.
-func New(l Logger, logLevel syslog.Priority, urlStrs []string) *graphd {
-	g := &graphd{}
+func New(l Logger, logLevel syslog.Priority, urlStrs []string, opts ...Option) *graphd {
+	g := &graphd{backoff: defaultBackoffConfig(), poolSize: 1, dialFallbackDelay: defaultDialFallbackDelay}
 
 	g.initLogger(l, logLevel)
 
@@ -40,5 +126,36 @@ func New(l Logger, logLevel syslog.Priority, urlStrs []string) *graphd {
 		g.LogFatalf("failed to initialize URLs: %v", err)
 	}
 
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.pool = newPool(g, g.poolSize)
+
+	return g
+}
+
+// NewWithFieldLogger is identical to New, except it takes a FieldLogger directly instead of a
+// Print-only Logger.  Use this when the caller already has a structured logger (zap, zerolog,
+// logrus, ...) and wants graphd's leveled log calls to carry their contextual fields through
+// without being flattened into a string first.
+func NewWithFieldLogger(fl FieldLogger, logLevel syslog.Priority, urlStrs []string, opts ...Option) *graphd {
+	g := &graphd{backoff: defaultBackoffConfig(), poolSize: 1, dialFallbackDelay: defaultDialFallbackDelay}
+
+	g.initFieldLogger(fl, logLevel)
+
+	if err := g.initURLs(urlStrs); err != nil {
+		g.LogFatalf("failed to initialize URLs: %v", err)
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.pool = newPool(g, g.poolSize)
+
 	return g
 }
+
+// PoolStats returns one ConnStats per connection in g's pool, in pool order.
+func (g *graphd) PoolStats() []ConnStats {
+	return g.pool.PoolStats()
+}
@@ -15,24 +15,23 @@ package graphd
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 )
 
-// readReponses returns a Response pointer slice read from an established connection to a graphd
-// database and a nil error on success.  On any failure to read a response, a Response pointer
-// slice containing zero-value Response pointers for the given failures, and the last encountered
-// error are returned.  readResponses must be called with g.conn locked.
+// readResponses reads reqsNum graphd replies off reader and returns them along with a nil error
+// on success.  On any failure to read a response, the returned slice contains zero-value
+// Response pointers for the failed reads, and the last encountered error is returned.
 // TODO: Would it be useful to return all encountered errors?  Any chance after a failure that
-//       we'll block on the following call to ReadString()?
-func (g *graphd) readResponses(reqsNum int) ([]*Response, error) {
+//       we'll block on the following call to readResponse()?
+func readResponses(reader *bufio.Reader, reqsNum int) ([]*Response, error) {
 	var retErr error
 	var resSlice []*Response
 
-	reader := bufio.NewReader(g.conn.netConn)
-
 	for i := 0; i < reqsNum; i++ {
-		str, err := reader.ReadString('\n')
+		str, err := readResponse(reader)
 		if err != nil {
 			resSlice = append(resSlice, NewResponse(""))
 			retErr = err
@@ -44,15 +43,82 @@ func (g *graphd) readResponses(reqsNum int) ([]*Response, error) {
 	return resSlice, retErr
 }
 
+// readResponse reads one graphd reply off reader.  Unlike a plain ReadString('\n'), it tracks
+// parenthesis depth and double-quote state so that a reply's terminating newline is only
+// recognized once any `(...)` group has closed and any trailing quoted message is complete --
+// graphd error messages may themselves contain embedded newlines inside their quoted string,
+// which would otherwise truncate the reply at the first one.
+func readResponse(reader *bufio.Reader) (string, error) {
+	var sb strings.Builder
+	depth := 0
+	inQuote := false
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteByte(b)
+
+		if inQuote {
+			// A backslash escapes the following byte (including a closing quote), so consume it
+			// too without leaving quote state.
+			if b == '\\' {
+				if next, err := reader.ReadByte(); err == nil {
+					sb.WriteByte(next)
+				}
+				continue
+			}
+			if b == '"' {
+				inQuote = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inQuote = true
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case '\n':
+			if depth == 0 {
+				return sb.String(), nil
+			}
+		}
+	}
+}
+
 // Query attempts to send the Request to the graphd database to which this instance of the library
 // is connected.  In the case of more than one Request, the requests are joined and sent as one.
 // If no connection is currently present, or if the established connection is stale, Query will
 // trigger a Redial.  A Response pointer slice containing responses from the graphd database (failed
 // responses are zero-value value Response pointers) is returned along with an error code.  In the
 // case of any failed responses, the returned error code will contain the last encountered error.
-// Query locks the connection, allowing one thread to Query at a time.
+// Query is a thin wrapper over QueryContext(context.Background(), ...).
 func (g *graphd) Query(reqs ...*Request) ([]*Response, error) {
-	g.conn.Lock()
+	return g.QueryContext(context.Background(), reqs...)
+}
+
+// QueryContext is Query, plus a ctx that governs the call: QueryContext fails fast if ctx is
+// already done, and if ctx is cancelled while this call is waiting to read its replies, the
+// underlying connection is closed to unblock it rather than leaving it parked in a read
+// indefinitely.  QueryContext pipelines: it only holds the connection's write lock for the
+// duration of the write, so other goroutines may queue their own writes while this call is still
+// awaiting its reply.
+func (g *graphd) QueryContext(ctx context.Context, reqs ...*Request) ([]*Response, error) {
+	return g.queryConnContext(ctx, g.pool.get(), reqs...)
+}
+
+// queryConnContext is the QueryContext implementation, parameterized over the connection to query
+// so that a Pool can dispatch calls across its member connections using the same logic.
+func (g *graphd) queryConnContext(ctx context.Context, c *connection, reqs ...*Request) ([]*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return []*Response{NewResponse("")}, err
+	}
 
 	// Join requests into one if needed.
 	var req *Request
@@ -63,68 +129,80 @@ func (g *graphd) Query(reqs ...*Request) ([]*Response, error) {
 		req = reqs[0]
 	}
 
-	g.LogDebugf("attempting to send '%v'", req)
-
-	sent := false
-	retries := 2
-	for sent == false {
-		var err error
-		var errStr string
-
-		switch g.conn.exists() {
-		// An established connection is present, try to send.
-		case true:
-			// Queries to graphd are new line terminated.
-			_, err = fmt.Fprintf(g.conn.netConn, "%v", req.body)
-			if err != nil {
-				// Set base error for failed send.
-				errStr = fmt.Sprintf("failed to send '%v': %v", req, err)
-				retries--
-				// If we've exhausted our retries, log and return error.
-				if retries == 0 {
-					g.LogErr(errStr)
-					g.conn.Unlock()
-					return []*Response{NewResponse("")}, errors.New(errStr)
-				}
-				// We can still retry, so try a Redial.  If it fails, append the error message to
-				// the base error, log and return the error.
-				g.conn.Unlock()
-				if err = g.Redial(0); err != nil {
-					errStr = fmt.Sprintf("%v: %v", errStr, err)
-					g.LogErr(errStr)
-					return []*Response{NewResponse("")}, errors.New(errStr)
-				}
-				// OK, we've redialed.  Lock the connection and let's try that send again.
-				g.conn.Lock()
-				g.LogErrf("%v: retrying (%v retries left)", errStr, retries)
-			} else {
-				// We've successfully sent.
-				g.LogDebugf("successfully sent '%v'", req)
-				sent = true
-			}
+	g.LogDebugKV("attempting to send", "req", req)
 
-		// No connection present, try to Dial.
-		case false:
-			g.conn.Unlock()
-			err = g.Dial(0)
-			if err != nil {
-				errStr = fmt.Sprintf("failed to send '%v': %v", req, err)
-				g.LogErr(errStr)
-				return []*Response{NewResponse("")}, errors.New(errStr)
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	ticket, err := g.sendConn(ctx, c, req)
+	if err != nil {
+		errStr := fmt.Sprintf("failed to send '%v': %v", req, err)
+		g.LogErr(errStr)
+		return []*Response{NewResponse("")}, errors.New(errStr)
+	}
+	g.LogDebugKV("successfully sent", "req", req)
+
+	// If ctx is cancelled while we're waiting our turn to read, or mid-read, close the
+	// connection to unblock the goroutine parked in ReadByte rather than leaving it stuck.
+	unblockDone := make(chan struct{})
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				c.closeForCancel()
+			case <-unblockDone:
 			}
-			g.conn.Lock()
-		}
+		}()
+	}
+	defer close(unblockDone)
+
+	if !c.awaitTurn(ticket) {
+		return []*Response{NewResponse("")}, errors.New("connection closed while awaiting turn to read response")
 	}
+	res, err := readResponses(c.reader, reqsNum)
+	c.finishTurn()
 
-	// We've successfully sent a query, now grab the responses and return them.
-	res, err := g.readResponses(reqsNum)
 	if err != nil {
 		errStr := fmt.Sprintf("failed to receive response to '%v': %v", req, err)
 		g.LogErr(errStr)
-		err = errors.New(errStr)
-	} else {
-		g.LogDebugf("received response '%v'", res)
+		return res, errors.New(errStr)
+	}
+	g.LogDebugKV("received response", "req", req, "res", res)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return res, ctxErr
+	}
+	return res, nil
+}
+
+// sendConn writes req to c and returns the read ticket the caller must wait on (via
+// c.awaitTurn) before reading its replies.  If c isn't yet connected, sendConn dials it first.  A
+// write failure pushes c to StateTransientFailure and returns the error immediately rather than
+// retrying inline: recovery is the background reconnector's job (see reconnectLoop), which
+// retries with a predictable, observable backoff policy instead of sendConn blocking the caller
+// on an ad hoc redial.
+func (g *graphd) sendConn(ctx context.Context, c *connection, req *Request) (readTicket, error) {
+	netConn := c.currentNetConn()
+	if netConn == nil {
+		if err := g.dialConnectionContext(ctx, c); err != nil {
+			return readTicket{}, err
+		}
+		netConn = c.currentNetConn()
+	}
+
+	c.writeMu.Lock()
+	_, err := fmt.Fprintf(netConn, "%v", req.body)
+	if err != nil {
+		// Clear netConn so the reconnector's next dialConnectionContext attempt doesn't mistake
+		// this broken socket for a usable connection (see connection.exists).
+		c.Lock()
+		c.netConn = nil
+		c.Unlock()
+		c.writeMu.Unlock()
+		c.setState(StateTransientFailure)
+		return readTicket{}, err
 	}
-	g.conn.Unlock()
-	return res, err
+	ticket := c.takeTurnLocked()
+	c.writeMu.Unlock()
+	return ticket, nil
 }
@@ -0,0 +1,84 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"bufio"
+	"context"
+	"log/syslog"
+	"strings"
+	"testing"
+	"time"
+
+	fakegraphd "github.com/google/graphd/go/graphd/test"
+)
+
+// TestReadResponseHandlesEmbeddedNewlineInQuotedMessage guards readResponse's depth/quote
+// tracking: a quoted error message may itself contain a newline, which must not be mistaken for
+// the reply's terminating newline.  It must also leave the reader positioned so the next
+// response can still be read whole.
+func TestReadResponseHandlesEmbeddedNewlineInQuotedMessage(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("error (SYSTEM) \"out of\nmemory\"\nok ()\n"))
+
+	first, err := readResponse(reader)
+	if err != nil {
+		t.Fatalf("readResponse (1st) error: %v", err)
+	}
+	wantFirst := "error (SYSTEM) \"out of\nmemory\"\n"
+	if first != wantFirst {
+		t.Errorf("readResponse (1st) = %q, want %q", first, wantFirst)
+	}
+
+	second, err := readResponse(reader)
+	if err != nil {
+		t.Fatalf("readResponse (2nd) error: %v", err)
+	}
+	if want := "ok ()\n"; second != want {
+		t.Errorf("readResponse (2nd) = %q, want %q", second, want)
+	}
+}
+
+// TestQueryContextCancelUnblocksMidRead guards the "closes the underlying connection when ctx is
+// cancelled mid-read to unblock the goroutine" behavior documented on QueryContext: the server
+// accepts the request but (via SetDropEveryNth) never replies, leaving the caller parked in
+// ReadByte until ctx is cancelled out from under it.
+func TestQueryContextCancelUnblocksMidRead(t *testing.T) {
+	addr := reserveAddr(t)
+	fg := fakegraphd.New(addr)
+	fg.SetReply("ok (1234.56.78)\n")
+	fg.SetDropEveryNth(1) // drop every request: the server never replies
+	stop, err := fg.Start()
+	if err != nil {
+		t.Fatalf("failed to start fakegraphd: %v", err)
+	}
+	defer stop()
+
+	g := New(testLogger, syslog.LOG_DEBUG, []string{"tcp://" + addr})
+	if err := g.Dial(5); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer g.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = g.QueryContext(ctx, NewRequest("get ()"))
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("QueryContext(cancelled mid-read) = nil error, want one reporting the closed connection")
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("QueryContext(cancelled mid-read) took %v to return, want well under 2s", elapsed)
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"fmt"
 	"log"
 	"log/syslog"
+	"strings"
+	"sync/atomic"
 )
 
 var logLevelStrs = map[syslog.Priority]string{
@@ -45,15 +47,71 @@ type Logger interface {
 	Print(v ...interface{})
 }
 
-// graphdLogger embeds the logger interface above.  logLevel is used to determine if the log
-// message should be emitted.
-type graphdLogger struct {
+// FieldLogger is a structured, leveled logging interface modelled on the loggers used by
+// dex/etcd: callers pick the severity by calling the matching method, and attach contextual
+// fields as alternating key/value pairs rather than baking them into a free-form string.  This
+// is the interface to implement to plug a structured logger (zap, zerolog, logrus, ...) into
+// graphd; callers with only a Print-style logger can keep using Logger, which is adapted to
+// FieldLogger automatically by printFieldLogger.
+type FieldLogger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+	Fatal(msg string, kv ...interface{})
+}
+
+// printFieldLogger adapts a Print-only Logger to FieldLogger, flattening the level and any kv
+// pairs into a single string of the form "level: msg key1=value1 key2=value2".  This keeps
+// existing Logger implementations (e.g. a log.Logger tied to syslog) working unchanged.
+type printFieldLogger struct {
 	Logger
-	logLevel syslog.Priority
 }
 
-// initLogger initializes a new graphdLogger.  If l is nil, default to using syslog with severity
-// LOG_ERR faciltiy LOG_USER.  logLevel is used to control whether writes to logger will be emitted.
+// format renders level, msg and kv as a single Print-able string.
+func (p printFieldLogger) format(level, msg string, kv ...interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(level)
+	sb.WriteString(": ")
+	sb.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&sb, " %v=%v", kv[i], kv[i+1])
+	}
+	return sb.String()
+}
+
+func (p printFieldLogger) Debug(msg string, kv ...interface{}) {
+	p.Print(p.format("debug", msg, kv...))
+}
+
+func (p printFieldLogger) Info(msg string, kv ...interface{}) {
+	p.Print(p.format("info", msg, kv...))
+}
+
+func (p printFieldLogger) Warn(msg string, kv ...interface{}) {
+	p.Print(p.format("warning", msg, kv...))
+}
+
+func (p printFieldLogger) Error(msg string, kv ...interface{}) {
+	p.Print(p.format("error", msg, kv...))
+}
+
+func (p printFieldLogger) Fatal(msg string, kv ...interface{}) {
+	p.Print(p.format("fatal", msg, kv...))
+}
+
+// graphdLogger embeds the FieldLogger interface above.  logLevel is used to determine if the log
+// message should be emitted; it is an atomic.Int32 rather than a plain syslog.Priority so that
+// logMaybe can read it lock-free on the hot path while SetLogLevel is mutated concurrently (e.g.
+// from an HTTP control endpoint on another goroutine).
+type graphdLogger struct {
+	FieldLogger
+	logLevel atomic.Int32
+}
+
+// initLogger initializes a new graphdLogger from a Print-only Logger, wrapping it in a
+// printFieldLogger.  If l is nil, default to using syslog with severity LOG_ERR facility
+// LOG_USER.  logLevel is used to control whether writes to logger will be emitted.
 func (g *graphd) initLogger(l Logger, logLevel syslog.Priority) {
 	loggerToUse := l
 	var err error
@@ -63,35 +121,55 @@ func (g *graphd) initLogger(l Logger, logLevel syslog.Priority) {
 			log.Fatalf("failed to initialize default system logger: %v", err)
 		}
 	}
-	g.logger = &graphdLogger{loggerToUse, logLevel}
+	g.initFieldLogger(printFieldLogger{loggerToUse}, logLevel)
+}
+
+// initFieldLogger initializes a new graphdLogger from a FieldLogger directly, for callers who
+// have a structured logger (zap, zerolog, logrus, ...) rather than a bare Print sink.
+func (g *graphd) initFieldLogger(fl FieldLogger, logLevel syslog.Priority) {
+	gl := &graphdLogger{FieldLogger: fl}
+	gl.logLevel.Store(int32(logLevel))
+	g.logger = gl
 }
 
 // GetLogLevel returns both a string and numerical representation of the currently set log level.
 func (g *graphd) GetLogLevel() (syslog.Priority, string) {
-	return g.logger.logLevel, logLevelToStr(g.logger.logLevel)
+	level := syslog.Priority(g.logger.logLevel.Load())
+	return level, logLevelToStr(level)
 }
 
-// SetLogLevel sets the log level of graphdLogger to logLevel.
+// SetLogLevel sets the log level of graphdLogger to logLevel.  It is safe to call concurrently
+// with logMaybe and with other calls to SetLogLevel.
 func (g *graphd) SetLogLevel(logLevel syslog.Priority) {
-	g.logger.logLevel = logLevel
+	g.logger.logLevel.Store(int32(logLevel))
 }
 
-// logMaybe sends s to the logger only if the priority p of the message is less than or equal to the
-// log level specified to initLogger().  If emitted, it will be sent with the priority carried by
-// the logger interface argument passed to initLogger.
-func (g *graphd) logMaybe(p syslog.Priority, s string) {
-	if p <= g.logger.logLevel {
-		logStr := logLevelToStr(p) + ": " + s
-		g.logger.Print(logStr)
+// logMaybe dispatches msg and kv to the FieldLogger method matching p, but only if the priority p
+// of the message is less than or equal to the log level specified to initLogger().  Syslog's
+// eight priorities are folded down onto FieldLogger's five levels: LOG_EMERG/LOG_ALERT/LOG_CRIT
+// collapse into Error alongside LOG_ERR, and LOG_NOTICE collapses into Warn alongside
+// LOG_WARNING.
+func (g *graphd) logMaybe(p syslog.Priority, msg string, kv ...interface{}) {
+	if p > syslog.Priority(g.logger.logLevel.Load()) {
+		return
+	}
+	switch p {
+	case syslog.LOG_EMERG, syslog.LOG_ALERT, syslog.LOG_CRIT, syslog.LOG_ERR:
+		g.logger.Error(msg, kv...)
+	case syslog.LOG_WARNING, syslog.LOG_NOTICE:
+		g.logger.Warn(msg, kv...)
+	case syslog.LOG_INFO:
+		g.logger.Info(msg, kv...)
+	default:
+		g.logger.Debug(msg, kv...)
 	}
 }
 
 // LogFatal logs at syslog.LOG_EMERG (highest priority) and follows up with a death call to log.Fatal,
 // triggering an os.Exit.
 func (g *graphd) LogFatal(s string) {
-	logStr := "fatal: " + s
-	g.logMaybe(syslog.LOG_EMERG, logStr)
-	log.Fatal(logStr)
+	g.logger.Fatal(s)
+	log.Fatal("fatal: " + s)
 }
 
 // LogFatalf logs at syslog.LOG_EMERG (highest priority) and follows up with a death call to log.Fatalf,
@@ -110,6 +188,11 @@ func (g *graphd) LogEmergf(format string, v ...interface{}) {
 	g.LogEmerg(fmt.Sprintf(format, v...))
 }
 
+// LogEmergKV logs at syslog.LOG_EMERG level, attaching kv as contextual fields.
+func (g *graphd) LogEmergKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_EMERG, s, kv...)
+}
+
 // LogAlert tries to log at syslog.LOG_ALERT level.
 func (g *graphd) LogAlert(s string) {
 	g.logMaybe(syslog.LOG_ALERT, s)
@@ -120,6 +203,11 @@ func (g *graphd) LogAlertf(format string, v ...interface{}) {
 	g.LogAlert(fmt.Sprintf(format, v...))
 }
 
+// LogAlertKV tries to log at syslog.LOG_ALERT level, attaching kv as contextual fields.
+func (g *graphd) LogAlertKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_ALERT, s, kv...)
+}
+
 // LogCrit tries to log at syslog.LOG_CRIT level.
 func (g *graphd) LogCrit(s string) {
 	g.logMaybe(syslog.LOG_CRIT, s)
@@ -130,6 +218,11 @@ func (g *graphd) LogCritf(format string, v ...interface{}) {
 	g.LogCrit(fmt.Sprintf(format, v...))
 }
 
+// LogCritKV tries to log at syslog.LOG_CRIT level, attaching kv as contextual fields.
+func (g *graphd) LogCritKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_CRIT, s, kv...)
+}
+
 // LogErr tries to log at syslog.LOG_ERR level.
 func (g *graphd) LogErr(s string) {
 	g.logMaybe(syslog.LOG_ERR, s)
@@ -140,6 +233,11 @@ func (g *graphd) LogErrf(format string, v ...interface{}) {
 	g.LogErr(fmt.Sprintf(format, v...))
 }
 
+// LogErrKV tries to log at syslog.LOG_ERR level, attaching kv as contextual fields.
+func (g *graphd) LogErrKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_ERR, s, kv...)
+}
+
 // LogWarn tries to log at syslog.LOG_WARNING level.
 func (g *graphd) LogWarn(s string) {
 	g.logMaybe(syslog.LOG_WARNING, s)
@@ -150,6 +248,11 @@ func (g *graphd) LogWarnf(format string, v ...interface{}) {
 	g.LogWarn(fmt.Sprintf(format, v...))
 }
 
+// LogWarnKV tries to log at syslog.LOG_WARNING level, attaching kv as contextual fields.
+func (g *graphd) LogWarnKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_WARNING, s, kv...)
+}
+
 // LogNotice tries to log at syslog.LOG_NOTICE level.
 func (g *graphd) LogNotice(s string) {
 	g.logMaybe(syslog.LOG_NOTICE, s)
@@ -160,6 +263,11 @@ func (g *graphd) LogNoticef(format string, v ...interface{}) {
 	g.LogNotice(fmt.Sprintf(format, v...))
 }
 
+// LogNoticeKV tries to log at syslog.LOG_NOTICE level, attaching kv as contextual fields.
+func (g *graphd) LogNoticeKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_NOTICE, s, kv...)
+}
+
 // LogInfo tries to log at syslog.LOG_INFO level.
 func (g *graphd) LogInfo(s string) {
 	g.logMaybe(syslog.LOG_INFO, s)
@@ -170,6 +278,11 @@ func (g *graphd) LogInfof(format string, v ...interface{}) {
 	g.LogInfo(fmt.Sprintf(format, v...))
 }
 
+// LogInfoKV tries to log at syslog.LOG_INFO level, attaching kv as contextual fields.
+func (g *graphd) LogInfoKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_INFO, s, kv...)
+}
+
 // LogDebug tries to log at syslog.LOG_DEBUG level.
 func (g *graphd) LogDebug(s string) {
 	g.logMaybe(syslog.LOG_DEBUG, s)
@@ -179,3 +292,8 @@ func (g *graphd) LogDebug(s string) {
 func (g *graphd) LogDebugf(format string, v ...interface{}) {
 	g.LogDebug(fmt.Sprintf(format, v...))
 }
+
+// LogDebugKV tries to log at syslog.LOG_DEBUG level, attaching kv as contextual fields.
+func (g *graphd) LogDebugKV(s string, kv ...interface{}) {
+	g.logMaybe(syslog.LOG_DEBUG, s, kv...)
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingFieldLogger is a FieldLogger that records every call instead of printing anything, so
+// tests can assert which level a message was routed to.
+type recordingFieldLogger struct {
+	mu    sync.Mutex
+	calls []fieldLoggerCall
+}
+
+type fieldLoggerCall struct {
+	level string
+	msg   string
+	kv    []interface{}
+}
+
+func (r *recordingFieldLogger) record(level, msg string, kv []interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fieldLoggerCall{level, msg, kv})
+}
+
+func (r *recordingFieldLogger) Debug(msg string, kv ...interface{}) { r.record("debug", msg, kv) }
+func (r *recordingFieldLogger) Info(msg string, kv ...interface{})  { r.record("info", msg, kv) }
+func (r *recordingFieldLogger) Warn(msg string, kv ...interface{})  { r.record("warning", msg, kv) }
+func (r *recordingFieldLogger) Error(msg string, kv ...interface{}) { r.record("error", msg, kv) }
+func (r *recordingFieldLogger) Fatal(msg string, kv ...interface{}) { r.record("fatal", msg, kv) }
+
+func (r *recordingFieldLogger) lastLevel() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.calls) == 0 {
+		return ""
+	}
+	return r.calls[len(r.calls)-1].level
+}
+
+// recordingPrinter is a Logger (Print-only) that records each rendered line.
+type recordingPrinter struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordingPrinter) Print(v ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, fmt.Sprint(v...))
+}
+
+func TestLogMaybeFoldsSyslogPrioritiesOntoFieldLoggerLevels(t *testing.T) {
+	tests := []struct {
+		priority  syslog.Priority
+		wantLevel string
+	}{
+		{syslog.LOG_EMERG, "error"},
+		{syslog.LOG_ALERT, "error"},
+		{syslog.LOG_CRIT, "error"},
+		{syslog.LOG_ERR, "error"},
+		{syslog.LOG_WARNING, "warning"},
+		{syslog.LOG_NOTICE, "warning"},
+		{syslog.LOG_INFO, "info"},
+		{syslog.LOG_DEBUG, "debug"},
+	}
+	for _, tt := range tests {
+		rl := &recordingFieldLogger{}
+		g := &graphd{}
+		g.initFieldLogger(rl, syslog.LOG_DEBUG)
+
+		g.logMaybe(tt.priority, "msg")
+
+		if got := rl.lastLevel(); got != tt.wantLevel {
+			t.Errorf("logMaybe(%v) routed to %q, want %q", tt.priority, got, tt.wantLevel)
+		}
+	}
+}
+
+func TestLogMaybeRespectsLogLevel(t *testing.T) {
+	rl := &recordingFieldLogger{}
+	g := &graphd{}
+	g.initFieldLogger(rl, syslog.LOG_WARNING)
+
+	g.LogInfo("suppressed: below configured level")
+	if len(rl.calls) != 0 {
+		t.Errorf("LogInfo logged %d messages at LOG_WARNING level, want 0", len(rl.calls))
+	}
+
+	g.LogWarn("emitted: at configured level")
+	if len(rl.calls) != 1 {
+		t.Fatalf("LogWarn logged %d messages, want 1", len(rl.calls))
+	}
+
+	g.SetLogLevel(syslog.LOG_DEBUG)
+	g.LogDebug("emitted: after SetLogLevel raised verbosity")
+	if len(rl.calls) != 2 {
+		t.Errorf("LogDebug after SetLogLevel logged %d messages, want 2 total", len(rl.calls))
+	}
+}
+
+func TestGetSetLogLevelRoundTrip(t *testing.T) {
+	g := &graphd{}
+	g.initFieldLogger(&recordingFieldLogger{}, syslog.LOG_ERR)
+
+	g.SetLogLevel(syslog.LOG_INFO)
+	level, str := g.GetLogLevel()
+	if level != syslog.LOG_INFO || str != "info" {
+		t.Errorf("GetLogLevel() = (%v, %q), want (%v, \"info\")", level, str, syslog.LOG_INFO)
+	}
+}
+
+func TestPrintFieldLoggerFormatsLevelMsgAndKV(t *testing.T) {
+	rp := &recordingPrinter{}
+	pfl := printFieldLogger{rp}
+
+	pfl.Warn("disk low", "pct", 91, "host", "db1")
+
+	if len(rp.lines) != 1 {
+		t.Fatalf("Warn() printed %d lines, want 1", len(rp.lines))
+	}
+	want := "warning: disk low pct=91 host=db1"
+	if rp.lines[0] != want {
+		t.Errorf("Warn() printed %q, want %q", rp.lines[0], want)
+	}
+}
+
+func TestInitLoggerRoutesThroughFieldLogger(t *testing.T) {
+	rp := &recordingPrinter{}
+	g := &graphd{}
+	g.initLogger(rp, syslog.LOG_DEBUG)
+
+	g.LogErr("boom")
+
+	if len(rp.lines) != 1 || !strings.HasPrefix(rp.lines[0], "error: boom") {
+		t.Errorf("LogErr printed %v, want one line starting with \"error: boom\"", rp.lines)
+	}
+}
+
+func TestSetLogLevelConcurrentWithLogMaybe(t *testing.T) {
+	g := &graphd{}
+	g.initFieldLogger(&recordingFieldLogger{}, syslog.LOG_DEBUG)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			g.SetLogLevel(syslog.LOG_INFO)
+		}()
+		go func() {
+			defer wg.Done()
+			g.LogDebug("racing against SetLogLevel")
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The logctl portion of the graphd package exposes runtime control over the log level, so an
+// operator can raise or lower verbosity on a running service without a restart.
+
+package graphd
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// strToLogLevel maps syslog level names (as produced by logLevelToStr) back to their
+// syslog.Priority, for parsing operator input.
+var strToLogLevel = func() map[string]syslog.Priority {
+	m := make(map[string]syslog.Priority, len(logLevelStrs))
+	for level, str := range logLevelStrs {
+		m[str] = level
+	}
+	return m
+}()
+
+// LogLevelHandler returns an http.Handler exposing the current log level for GET requests, and
+// accepting PUT requests whose body is a syslog level name (e.g. "debug", "error") to change it
+// at runtime -- the etcd dynamic-log-level pattern.  This lets an operator flip a running service
+// to "debug" for a live incident and back to "error" afterwards without a restart.
+func (g *graphd) LogLevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, levelStr := g.GetLogLevel()
+			fmt.Fprintln(w, levelStr)
+
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+				return
+			}
+			levelStr := strings.TrimSpace(string(body))
+			level, ok := strToLogLevel[levelStr]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown log level %q", levelStr), http.StatusBadRequest)
+				return
+			}
+			g.SetLogLevel(level)
+			g.LogNoticeKV("log level changed via control endpoint", "level", levelStr)
+			fmt.Fprintln(w, levelStr)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// WatchSIGHUPForLogLevel starts a goroutine that toggles the log level between the level active
+// when it was called and syslog.LOG_DEBUG each time the process receives SIGHUP, for
+// environments without an HTTP surface to host LogLevelHandler on.  The first SIGHUP enables
+// debug logging for a live incident; the second restores the prior level.  The returned function
+// stops the watch and restores the original log level.
+func (g *graphd) WatchSIGHUPForLogLevel() func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	baseLevel, _ := g.GetLogLevel()
+	debug := false
+
+	go func() {
+		for range sigCh {
+			if debug {
+				g.SetLogLevel(baseLevel)
+				g.LogNotice("SIGHUP received, restoring log level")
+			} else {
+				g.SetLogLevel(syslog.LOG_DEBUG)
+				g.LogNotice("SIGHUP received, enabling debug logging")
+			}
+			debug = !debug
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+		g.SetLogLevel(baseLevel)
+	}
+}
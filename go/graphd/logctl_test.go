@@ -0,0 +1,115 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"log/syslog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestGraphd(t *testing.T, level syslog.Priority) *graphd {
+	t.Helper()
+	g := &graphd{}
+	g.initFieldLogger(&recordingFieldLogger{}, level)
+	return g
+}
+
+func TestLogLevelHandlerGet(t *testing.T) {
+	g := newTestGraphd(t, syslog.LOG_WARNING)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/loglevel", nil)
+	g.LogLevelHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "warning" {
+		t.Errorf("GET body = %q, want \"warning\"", got)
+	}
+}
+
+func TestLogLevelHandlerPutValid(t *testing.T) {
+	g := newTestGraphd(t, syslog.LOG_WARNING)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader("debug"))
+	g.LogLevelHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if level, _ := g.GetLogLevel(); level != syslog.LOG_DEBUG {
+		t.Errorf("GetLogLevel() after PUT debug = %v, want %v", level, syslog.LOG_DEBUG)
+	}
+}
+
+func TestLogLevelHandlerPutUnknownLevel(t *testing.T) {
+	g := newTestGraphd(t, syslog.LOG_WARNING)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader("nonsense"))
+	g.LogLevelHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT \"nonsense\" status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if level, _ := g.GetLogLevel(); level != syslog.LOG_WARNING {
+		t.Errorf("GetLogLevel() after rejected PUT = %v, want unchanged %v", level, syslog.LOG_WARNING)
+	}
+}
+
+func TestLogLevelHandlerMethodNotAllowed(t *testing.T) {
+	g := newTestGraphd(t, syslog.LOG_WARNING)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/loglevel", nil)
+	g.LogLevelHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWatchSIGHUPForLogLevelTogglesAndRestores(t *testing.T) {
+	g := newTestGraphd(t, syslog.LOG_WARNING)
+	stop := g.WatchSIGHUPForLogLevel()
+
+	waitForLevel := func(want syslog.Priority) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if level, _ := g.GetLogLevel(); level == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("log level never reached %v", want)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitForLevel(syslog.LOG_DEBUG)
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitForLevel(syslog.LOG_WARNING)
+
+	stop()
+	if level, _ := g.GetLogLevel(); level != syslog.LOG_WARNING {
+		t.Errorf("GetLogLevel() after stop() = %v, want original %v", level, syslog.LOG_WARNING)
+	}
+}
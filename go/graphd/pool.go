@@ -0,0 +1,197 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The pool portion of the graphd package spreads Query traffic across more than one connection,
+// so a single slow or busy connection can't head-of-line block every caller.
+
+package graphd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Conn reports the observable state of a single pooled connection: its own socket, lock and
+// state machine (see the unexported *connection, which implements it).  It's the read-only
+// surface a Pool uses to choose where to dispatch the next request and to report PoolStats.
+type Conn interface {
+	State() ConnState
+	InFlight() int32
+}
+
+// ConnStats is a Conn's state and in-flight request count at the moment PoolStats was called.
+type ConnStats struct {
+	State    ConnState
+	InFlight int32
+}
+
+// Pool owns a fixed number of connections to the URLs configured on a graphd instance, handing
+// one out per QueryContext call by least in-flight request count (ties broken round-robin).
+// Each member connection supports the same write/read pipelining, state machine and
+// backoff-based auto-reconnect as a bare graphd's own connection; a per-member reconnector means
+// one dead pool member doesn't hold up the others.
+type Pool struct {
+	g *graphd
+
+	mu    sync.Mutex
+	conns []*connection
+	next  int
+}
+
+// newPool returns a Pool of size lazily-primed connections; none are dialed until Dial,
+// DialContext, Redial or RedialContext is called on it (directly, or via the owning graphd's own
+// Dial/DialContext/Redial/RedialContext, which fan out across every pool member).  A size <= 0 is
+// treated as 1.
+func newPool(g *graphd, size int) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+
+	p := &Pool{g: g}
+	for i := 0; i < size; i++ {
+		p.conns = append(p.conns, primeConnection())
+	}
+	return p
+}
+
+// NewPool is newPool, plus an eager Dial of every member connection; it returns an error if any
+// member fails to dial.  Use NewPool for a standalone Pool that should be connected up front; a
+// graphd instance's own WithPoolSize-configured pool is primed lazily instead, and dialed by
+// graphd's Dial/DialContext.
+func NewPool(g *graphd, size int) (*Pool, error) {
+	p := newPool(g, size)
+	for i, c := range p.conns {
+		if err := g.dialConnection(c, 0); err != nil {
+			return nil, fmt.Errorf("failed to dial pool connection %d/%d: %v", i+1, size, err)
+		}
+	}
+	return p, nil
+}
+
+// primary returns the pool's first member connection, used to answer graphd.State and
+// graphd.WaitForStateChange -- calls that predate pooling and report on a single representative
+// connection rather than the whole pool (see PoolStats for the full picture).
+func (p *Pool) primary() *connection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conns[0]
+}
+
+// get returns the least-loaded member connection, i.e. the one with the fewest in-flight
+// requests; ties are broken in round-robin order.
+func (p *Pool) get() *connection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := p.next
+	for i := 1; i < len(p.conns); i++ {
+		idx := (p.next + i) % len(p.conns)
+		if p.conns[idx].InFlight() < p.conns[best].InFlight() {
+			best = idx
+		}
+	}
+	p.next = (best + 1) % len(p.conns)
+	return p.conns[best]
+}
+
+// Query sends reqs over the pool's least-loaded connection.  It is a thin wrapper over
+// QueryContext(context.Background(), ...).
+func (p *Pool) Query(reqs ...*Request) ([]*Response, error) {
+	return p.QueryContext(context.Background(), reqs...)
+}
+
+// QueryContext sends reqs over the pool's least-loaded connection, honoring ctx the same way
+// graphd.QueryContext does.
+func (p *Pool) QueryContext(ctx context.Context, reqs ...*Request) ([]*Response, error) {
+	c := p.get()
+	return p.g.queryConnContext(ctx, c, reqs...)
+}
+
+// Dial is DialContext, wrapped with a context.WithTimeout built from t seconds (0 meaning no
+// timeout).
+func (p *Pool) Dial(t int) error {
+	ctx, cancel := ctxFromTimeout(t)
+	defer cancel()
+	return p.DialContext(ctx)
+}
+
+// DialContext dials every member connection under ctx, in parallel.  An already-connected member
+// is left untouched.  DialContext returns the last encountered error, if any, but always attempts
+// every member regardless of earlier failures.
+func (p *Pool) DialContext(ctx context.Context) error {
+	return p.forEachConn(func(c *connection) error {
+		return p.g.dialConnectionContext(ctx, c)
+	})
+}
+
+// Redial is RedialContext, wrapped with a context.WithTimeout built from t seconds (0 meaning no
+// timeout).
+func (p *Pool) Redial(t int) error {
+	ctx, cancel := ctxFromTimeout(t)
+	defer cancel()
+	return p.RedialContext(ctx)
+}
+
+// RedialContext disconnects and redials every member connection under ctx, in parallel.
+// RedialContext returns the last encountered error, if any, but always attempts every member
+// regardless of earlier failures.
+func (p *Pool) RedialContext(ctx context.Context) error {
+	return p.forEachConn(func(c *connection) error {
+		return p.g.redialConnectionContext(ctx, c)
+	})
+}
+
+// Disconnect closes every connection in the pool, in parallel.  It returns the last encountered
+// error, if any, but attempts to disconnect all member connections regardless of earlier
+// failures.
+func (p *Pool) Disconnect() error {
+	return p.forEachConn(p.g.disconnectConnection)
+}
+
+// PoolStats returns one ConnStats per member connection, in pool order.
+func (p *Pool) PoolStats() []ConnStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]ConnStats, len(p.conns))
+	for i, c := range p.conns {
+		stats[i] = ConnStats{State: c.State(), InFlight: c.InFlight()}
+	}
+	return stats
+}
+
+// forEachConn runs fn against every member connection in parallel, waits for them all to
+// complete, and returns the last encountered error, if any.
+func (p *Pool) forEachConn(fn func(*connection) error) error {
+	p.mu.Lock()
+	conns := append([]*connection(nil), p.conns...)
+	p.mu.Unlock()
+
+	var mu sync.Mutex
+	var retErr error
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(c); err != nil {
+				mu.Lock()
+				retErr = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return retErr
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"context"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	fakegraphd "github.com/google/graphd/go/graphd/test"
+)
+
+var testLogger = log.New(os.Stderr, "", 0)
+
+// reserveAddr returns a loopback address nothing is listening on yet, for handing to a
+// fakegraphd.New that binds it itself: reserving (rather than hardcoding) the port avoids
+// collisions with other tests or services on the machine.
+func reserveAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve addr: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestPoolLeastLoadedDispatch(t *testing.T) {
+	addr := reserveAddr(t)
+	fg := fakegraphd.New(addr)
+	fg.SetReply("ok (1234.56.78)\n")
+	fg.SetLatency(50 * time.Millisecond)
+	stop, err := fg.Start()
+	if err != nil {
+		t.Fatalf("failed to start fakegraphd: %v", err)
+	}
+	defer stop()
+
+	g := New(testLogger, syslog.LOG_DEBUG, []string{"tcp://" + addr}, WithPoolSize(3))
+	if err := g.Dial(5); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer g.Disconnect()
+
+	// Hold one pool member busy with a slow in-flight request, then ask the pool for a connection
+	// several times and confirm it never picks the busy member.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		g.Query(NewRequest("slow ()"))
+	}()
+	time.Sleep(10 * time.Millisecond) // give the slow request time to claim a member
+
+	for i := 0; i < 4; i++ {
+		c := g.pool.get()
+		if c.InFlight() != 0 {
+			t.Errorf("request %d dispatched to a connection with InFlight = %d, want an idle member", i, c.InFlight())
+		}
+	}
+	wg.Wait()
+}
+
+func TestPoolFanOutDialDisconnectRedial(t *testing.T) {
+	addr := reserveAddr(t)
+	fg := fakegraphd.New(addr)
+	fg.SetReply("ok (1234.56.78)\n")
+	stop, err := fg.Start()
+	if err != nil {
+		t.Fatalf("failed to start fakegraphd: %v", err)
+	}
+	defer stop()
+
+	g := New(testLogger, syslog.LOG_DEBUG, []string{"tcp://" + addr}, WithPoolSize(3))
+	if err := g.Dial(5); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	for i, s := range g.PoolStats() {
+		if s.State != StateReady {
+			t.Errorf("member %d State = %v, want StateReady", i, s.State)
+		}
+	}
+
+	if err := g.Redial(5); err != nil {
+		t.Fatalf("Redial failed: %v", err)
+	}
+	for i, s := range g.PoolStats() {
+		if s.State != StateReady {
+			t.Errorf("member %d State after Redial = %v, want StateReady", i, s.State)
+		}
+	}
+
+	if err := g.Disconnect(); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+	for i, s := range g.PoolStats() {
+		if s.State != StateShutdown {
+			t.Errorf("member %d State after Disconnect = %v, want StateShutdown", i, s.State)
+		}
+	}
+}
+
+func TestPoolDialPartialFailure(t *testing.T) {
+	// Only the second URL is actually listening; Dial should still succeed for every member by
+	// falling back to it, and report no error despite the first URL being unreachable.
+	dead := unreachableAddr(t)
+	addr := reserveAddr(t)
+	fg := fakegraphd.New(addr)
+	fg.SetReply("ok (1234.56.78)\n")
+	stop, err := fg.Start()
+	if err != nil {
+		t.Fatalf("failed to start fakegraphd: %v", err)
+	}
+	defer stop()
+
+	g := New(testLogger, syslog.LOG_DEBUG, []string{"tcp://" + dead, "tcp://" + addr}, WithPoolSize(2))
+	if err := g.DialContext(context.Background()); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer g.Disconnect()
+
+	for i, s := range g.PoolStats() {
+		if s.State != StateReady {
+			t.Errorf("member %d State = %v, want StateReady", i, s.State)
+		}
+	}
+}
+
+func TestPoolStatsInFlight(t *testing.T) {
+	addr := reserveAddr(t)
+	fg := fakegraphd.New(addr)
+	fg.SetReply("ok (1234.56.78)\n")
+	fg.SetLatency(50 * time.Millisecond)
+	stop, err := fg.Start()
+	if err != nil {
+		t.Fatalf("failed to start fakegraphd: %v", err)
+	}
+	defer stop()
+
+	g := New(testLogger, syslog.LOG_DEBUG, []string{"tcp://" + addr}, WithPoolSize(1))
+	if err := g.Dial(5); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer g.Disconnect()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		g.Query(NewRequest("status ()"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	stats := g.PoolStats()
+	if stats[0].InFlight != 1 {
+		t.Errorf("InFlight = %d, want 1", stats[0].InFlight)
+	}
+	wg.Wait()
+
+	stats = g.PoolStats()
+	if stats[0].InFlight != 0 {
+		t.Errorf("InFlight after completion = %d, want 0", stats[0].InFlight)
+	}
+}
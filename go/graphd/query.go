@@ -40,6 +40,35 @@ func (r *Response) String() string {
 	return strings.TrimSpace(r.body)
 }
 
+// Raw returns the unparsed response body exactly as received from graphd, trimmed of leading and
+// trailing whitespace.  It is an escape hatch for callers who want the old string-based behaviour
+// instead of Parse's typed tree.
+func (r *Response) Raw() string {
+	return r.String()
+}
+
+// IsError reports whether the response is a graphd "error (...)" reply.  It parses the response
+// anew on every call; no parse result is cached.
+func (r *Response) IsError() bool {
+	status, _, err := r.Parse()
+	return err == nil && status == StatusError
+}
+
+// ErrorMessage returns the quoted message graphd attached to an "error (...)" reply, or "" if the
+// response is not an error or could not be parsed.
+func (r *Response) ErrorMessage() string {
+	status, values, err := r.Parse()
+	if err != nil || status != StatusError {
+		return ""
+	}
+	for _, v := range values {
+		if v.Kind == ValueString {
+			return v.Str
+		}
+	}
+	return ""
+}
+
 // NewRequest returns a Request pointer initialized from the string parameter.  The parameter should
 // represent one request to be sent to a graphd database.  A new line is automatically added.
 func NewRequest(s string) *Request {
@@ -0,0 +1,63 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The reconnect portion of the graphd package redials a connection in the background, with
+// exponential backoff, whenever it reports StateTransientFailure.
+
+package graphd
+
+import (
+	"context"
+	"time"
+)
+
+// sustainedReadyDuration is how long a connection must remain StateReady after a reconnect
+// before reconnectLoop resets its backoff attempt counter back to zero.  Without this, a
+// connection that flaps (reconnects, then immediately fails again) would keep retrying at the
+// initial backoff rate instead of continuing to back off.
+const sustainedReadyDuration = 10 * time.Second
+
+// reconnectLoop is c's background reconnector (see connection.ensureReconnectLoop): it waits for c
+// to report StateTransientFailure, then retries dialConnectionContext with exponential backoff
+// until c reconnects or is shut down.  reconnectLoop returns once c reaches StateShutdown.  It is
+// not the only dialer of c: sendConn also dials inline when a Query finds c without a netConn,
+// and the two are serialized only by c's lock, the second finding c already connected.
+func (g *graphd) reconnectLoop(c *connection) {
+	attempt := 0
+	for {
+		if !c.awaitState(StateTransientFailure) {
+			return
+		}
+
+		delay := g.backoff.next(attempt)
+		g.LogDebugKV("reconnecting after backoff", "delay", delay, "attempt", attempt)
+		time.Sleep(delay)
+		if c.State() == StateShutdown {
+			return
+		}
+
+		if err := g.dialConnectionContext(context.Background(), c); err != nil {
+			g.LogErrKV("reconnect attempt failed", "attempt", attempt, "error", err)
+			attempt++
+			continue
+		}
+		g.LogDebugKV("reconnected", "attempt", attempt)
+
+		ctx, cancel := context.WithTimeout(context.Background(), sustainedReadyDuration)
+		flapped := c.WaitForStateChange(ctx, StateReady)
+		cancel()
+		if flapped {
+			attempt++
+		} else {
+			attempt = 0
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The response portion of the graphd package parses the parenthesized, S-expression-style
+// payload graphd emits in its replies, so callers don't have to reimplement parsing of the
+// "ok (...)" / "error (...) \"message\"" reply grammar themselves.
+
+package graphd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ResponseStatus classifies the outcome reported by a graphd Response.
+type ResponseStatus int
+
+const (
+	// StatusEmpty is the zero value, returned for a Response with no parsable body.
+	StatusEmpty ResponseStatus = iota
+	// StatusOK is returned for an "ok (...)" reply.
+	StatusOK
+	// StatusError is returned for an "error (...) ..." reply.
+	StatusError
+)
+
+// String implements stringer interface for a ResponseStatus.
+func (s ResponseStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	default:
+		return "empty"
+	}
+}
+
+// ValueKind distinguishes the shapes a Value in a parsed Response can take.
+type ValueKind int
+
+const (
+	// ValueAtom is an unquoted token, e.g. a GUID, timestamp, number or bareword.
+	ValueAtom ValueKind = iota
+	// ValueString is a quoted, unquoted-on-parse string.
+	ValueString
+	// ValueList is a parenthesized group of Values.
+	ValueList
+)
+
+// Value is one node of the tree Parse produces from a graphd reply's parenthesized payload.
+// Exactly one of Atom, Str or List is meaningful, depending on Kind.
+type Value struct {
+	Kind ValueKind
+	Atom string  // set when Kind == ValueAtom; the raw token (GUID, timestamp, bareword, ...)
+	Str  string  // set when Kind == ValueString; the unquoted string
+	List []Value // set when Kind == ValueList; the parenthesized group's children
+}
+
+// Parse tokenizes the Response body into a ResponseStatus and the list of Values found inside
+// its parenthesized payload.  It understands graphd's two reply shapes:
+//
+//	ok (value value ...)
+//	error (code) "message"
+//
+// For an error reply, the returned Values contain both the code group's children and, appended
+// last, a ValueString holding the unquoted message.  Parse returns an error if the body isn't
+// recognizable as either shape.
+func (r *Response) Parse() (ResponseStatus, []Value, error) {
+	body := strings.TrimSpace(r.body)
+	if body == "" {
+		return StatusEmpty, nil, nil
+	}
+
+	p := &responseParser{s: body}
+
+	word := p.readWord()
+	var status ResponseStatus
+	switch word {
+	case "ok":
+		status = StatusOK
+	case "error":
+		status = StatusError
+	default:
+		return StatusEmpty, nil, fmt.Errorf("unrecognized response status %q in %q", word, body)
+	}
+
+	p.skipSpace()
+	if p.peek() != '(' {
+		return StatusEmpty, nil, fmt.Errorf("expected '(' after %q in %q", word, body)
+	}
+	group, err := p.readList()
+	if err != nil {
+		return StatusEmpty, nil, err
+	}
+	values := group
+
+	p.skipSpace()
+	if p.peek() == '"' {
+		msg, err := p.readQuotedString()
+		if err != nil {
+			return StatusEmpty, nil, err
+		}
+		values = append(values, Value{Kind: ValueString, Str: msg})
+	}
+
+	return status, values, nil
+}
+
+// responseParser is a minimal hand-rolled tokenizer over a graphd reply body.
+type responseParser struct {
+	s   string
+	pos int
+}
+
+func (p *responseParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *responseParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n' || p.s[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// readWord reads a bareword up to the next space, '(' or ')' and returns it.
+func (p *responseParser) readWord() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')' {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// readQuotedString reads a double-quoted string starting at the current position, unescaping
+// \" and \\ sequences, and returns the unquoted contents.
+func (p *responseParser) readQuotedString() (string, error) {
+	if p.peek() != '"' {
+		return "", errors.New("expected '\"' to start a quoted string")
+	}
+	p.pos++ // consume opening quote
+
+	var sb strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '\\' && p.pos+1 < len(p.s) {
+			sb.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if c == '"' {
+			p.pos++
+			return sb.String(), nil
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return "", errors.New("unterminated quoted string")
+}
+
+// readValue reads one Value -- an atom, a quoted string, or a parenthesized list -- starting at
+// the current position.
+func (p *responseParser) readValue() (Value, error) {
+	p.skipSpace()
+	switch p.peek() {
+	case '"':
+		s, err := p.readQuotedString()
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Kind: ValueString, Str: s}, nil
+	case '(':
+		list, err := p.readList()
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Kind: ValueList, List: list}, nil
+	default:
+		atom := p.readWord()
+		if atom == "" {
+			return Value{}, fmt.Errorf("expected a value at position %d in %q", p.pos, p.s)
+		}
+		return Value{Kind: ValueAtom, Atom: atom}, nil
+	}
+}
+
+// readList reads a parenthesized, space-separated group of Values and returns its children.
+func (p *responseParser) readList() ([]Value, error) {
+	if p.peek() != '(' {
+		return nil, fmt.Errorf("expected '(' at position %d in %q", p.pos, p.s)
+	}
+	p.pos++ // consume '('
+
+	var values []Value
+	for {
+		p.skipSpace()
+		if p.peek() == ')' {
+			p.pos++
+			return values, nil
+		}
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("unterminated list in %q", p.s)
+		}
+		v, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+}
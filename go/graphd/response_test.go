@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import "testing"
+
+func TestResponseParseOK(t *testing.T) {
+	r := NewResponse("ok (1234.56.78 \"hello\" (a b c))\n")
+	status, values, err := r.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != StatusOK {
+		t.Fatalf("status = %v, want StatusOK", status)
+	}
+	if len(values) != 3 {
+		t.Fatalf("values = %+v, want 3 entries", values)
+	}
+	if values[0].Kind != ValueAtom || values[0].Atom != "1234.56.78" {
+		t.Errorf("values[0] = %+v, want atom 1234.56.78", values[0])
+	}
+	if values[1].Kind != ValueString || values[1].Str != "hello" {
+		t.Errorf("values[1] = %+v, want string hello", values[1])
+	}
+	if values[2].Kind != ValueList || len(values[2].List) != 3 {
+		t.Errorf("values[2] = %+v, want a 3-element list", values[2])
+	}
+}
+
+func TestResponseParseError(t *testing.T) {
+	r := NewResponse("error (SYSTEM) \"out of\nmemory\"\n")
+	status, values, err := r.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != StatusError {
+		t.Fatalf("status = %v, want StatusError", status)
+	}
+	if len(values) != 2 {
+		t.Fatalf("values = %+v, want a code and a message", values)
+	}
+	if values[1].Str != "out of\nmemory" {
+		t.Errorf("message = %q, want %q", values[1].Str, "out of\nmemory")
+	}
+	if !r.IsError() {
+		t.Error("IsError() = false, want true")
+	}
+	if got, want := r.ErrorMessage(), "out of\nmemory"; got != want {
+		t.Errorf("ErrorMessage() = %q, want %q", got, want)
+	}
+}
+
+func TestResponseParseEmpty(t *testing.T) {
+	r := NewResponse("")
+	status, values, err := r.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != StatusEmpty {
+		t.Fatalf("status = %v, want StatusEmpty", status)
+	}
+	if len(values) != 0 {
+		t.Errorf("values = %+v, want none", values)
+	}
+}
+
+func TestResponseRaw(t *testing.T) {
+	r := NewResponse(" ok ()\n")
+	if got, want := r.Raw(), "ok ()"; got != want {
+		t.Errorf("Raw() = %q, want %q", got, want)
+	}
+}
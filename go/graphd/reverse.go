@@ -0,0 +1,548 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The reverse portion of the graphd package lets a graphd client behind NAT (a ReverseDialer)
+// expose itself to a public coordinator (a ReverseListener), which then initiates graphd sessions
+// back through the tunnel instead of connecting to the client directly.  A single outbound
+// control connection from dialer to coordinator carries many logical streams, each identified by
+// an 8-byte stream ID and multiplexed over that one socket.
+package graphd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reverseFrameType identifies what a frame multiplexed over a reverse-dial control connection
+// represents.
+type reverseFrameType byte
+
+const (
+	// reverseFrameOpen is sent coordinator -> dialer to start a new logical stream.
+	reverseFrameOpen reverseFrameType = 1
+	// reverseFrameData carries payload for an already-open stream, in either direction.
+	reverseFrameData reverseFrameType = 2
+	// reverseFrameClose signals a stream has ended, in either direction.
+	reverseFrameClose reverseFrameType = 3
+)
+
+// reverseFrameHeaderLen is the size, in bytes, of a frame's fixed-size prefix: 1 byte type, 8
+// byte stream ID, 4 byte payload length.
+const reverseFrameHeaderLen = 13
+
+// reverseFrameHeader is the fixed-size prefix of every frame multiplexed over a reverse-dial
+// control connection.
+type reverseFrameHeader struct {
+	typ      reverseFrameType
+	streamID uint64
+	length   uint32
+}
+
+// writeReverseFrame writes typ/streamID/payload to w as a single frame.  A nil or empty payload
+// is valid for reverseFrameOpen and reverseFrameClose, which carry no data of their own.
+func writeReverseFrame(w io.Writer, typ reverseFrameType, streamID uint64, payload []byte) error {
+	header := make([]byte, reverseFrameHeaderLen)
+	header[0] = byte(typ)
+	binary.BigEndian.PutUint64(header[1:9], streamID)
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readReverseFrame reads one frame off r, blocking until a full header (and, for
+// reverseFrameData, its payload) has arrived.
+func readReverseFrame(r io.Reader) (reverseFrameHeader, []byte, error) {
+	header := make([]byte, reverseFrameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return reverseFrameHeader{}, nil, err
+	}
+
+	h := reverseFrameHeader{
+		typ:      reverseFrameType(header[0]),
+		streamID: binary.BigEndian.Uint64(header[1:9]),
+		length:   binary.BigEndian.Uint32(header[9:13]),
+	}
+	if h.length == 0 {
+		return h, nil, nil
+	}
+
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return h, nil, err
+	}
+	return h, payload, nil
+}
+
+// reverseHello is the JSON handshake a ReverseDialer sends immediately after connecting to a
+// coordinator, identifying itself so the coordinator can later target it via
+// ReverseListener.DialerConn.  It is sent as a single line terminated by '\n', before any framed
+// data follows.
+type reverseHello struct {
+	DialerID string `json:"dialer_id"`
+}
+
+// reverseAddr is the net.Addr reported by a demuxed reverseStream's RemoteAddr, since the only
+// thing identifying one is its multiplexed stream ID rather than a real socket address.
+type reverseAddr struct {
+	network string
+	id      uint64
+}
+
+func (a reverseAddr) Network() string { return a.network }
+func (a reverseAddr) String() string  { return fmt.Sprintf("reverse:%d", a.id) }
+
+// reverseStream is a single logical connection multiplexed over a shared reverse-dial control
+// connection.  It implements net.Conn: incoming reverseFrameData payloads are delivered into a
+// pipe that Read drains, while Write frames its argument as reverseFrameData and hands it to send
+// to serialize onto the shared control connection alongside every other stream's writes.
+type reverseStream struct {
+	id                    uint64
+	send                  func(typ reverseFrameType, streamID uint64, payload []byte) error
+	reader                *io.PipeReader
+	writer                *io.PipeWriter
+	localAddr, remoteAddr net.Addr
+
+	closeOnce sync.Once
+}
+
+// newReverseStream returns a reverseStream identified by id, whose writes are serialized via
+// send and whose Read is fed by deliver.
+func newReverseStream(id uint64, send func(reverseFrameType, uint64, []byte) error, local, remote net.Addr) *reverseStream {
+	r, w := io.Pipe()
+	return &reverseStream{id: id, send: send, reader: r, writer: w, localAddr: local, remoteAddr: remote}
+}
+
+// deliver feeds payload from an incoming reverseFrameData frame to whatever Read call is blocked
+// on s.
+func (s *reverseStream) deliver(payload []byte) error {
+	_, err := s.writer.Write(payload)
+	return err
+}
+
+func (s *reverseStream) Read(p []byte) (int, error) { return s.reader.Read(p) }
+
+func (s *reverseStream) Write(p []byte) (int, error) {
+	if err := s.send(reverseFrameData, s.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close tells the peer this stream has ended and unblocks any pending local Read.  It is safe to
+// call more than once.
+func (s *reverseStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.writer.Close()
+		s.send(reverseFrameClose, s.id, nil)
+	})
+	return nil
+}
+
+func (s *reverseStream) LocalAddr() net.Addr  { return s.localAddr }
+func (s *reverseStream) RemoteAddr() net.Addr { return s.remoteAddr }
+
+// Deadlines aren't meaningful for a stream backed by an in-memory pipe rather than a socket;
+// these are no-ops purely so reverseStream satisfies net.Conn.
+func (s *reverseStream) SetDeadline(t time.Time) error      { return nil }
+func (s *reverseStream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *reverseStream) SetWriteDeadline(t time.Time) error { return nil }
+
+// ReverseDialer is the client side of graphd's NAT-traversal reverse-dial tunnel (see
+// WithReverseDial): it opens one outbound control connection to a coordinator, identifies itself
+// with a dialer ID, and demultiplexes the coordinator's reverseFrameOpen frames into independent
+// net.Conns -- one per graphd pool member -- that the existing connection machinery uses exactly
+// like a directly dialed socket.
+type ReverseDialer struct {
+	coordinatorURL string
+	dialerID       string
+	tlsConfig      *tls.Config
+
+	connectMu sync.Mutex // serializes ensureConnected across concurrent pool members
+
+	mu      sync.Mutex
+	ctrl    net.Conn
+	writeMu sync.Mutex
+	streams map[uint64]*reverseStream
+	closed  bool
+
+	accept chan *reverseStream
+	done   chan struct{}
+}
+
+// NewReverseDialer returns a ReverseDialer that will connect to coordinatorURL and identify
+// itself as dialerID once ensureConnected (via Dial/DialContext) is called.
+func NewReverseDialer(coordinatorURL, dialerID string) *ReverseDialer {
+	return &ReverseDialer{
+		coordinatorURL: coordinatorURL,
+		dialerID:       dialerID,
+		streams:        make(map[uint64]*reverseStream),
+		accept:         make(chan *reverseStream),
+		done:           make(chan struct{}),
+	}
+}
+
+// ensureConnected establishes the control connection if it isn't already up, idempotently so
+// every graphd pool member's dialConnectionContext can share the one ReverseDialer.
+func (d *ReverseDialer) ensureConnected(ctx context.Context) error {
+	d.connectMu.Lock()
+	defer d.connectMu.Unlock()
+
+	d.mu.Lock()
+	connected := d.ctrl != nil && !d.closed
+	d.mu.Unlock()
+	if connected {
+		return nil
+	}
+	return d.connect(ctx)
+}
+
+// connect dials d.coordinatorURL, sends d's hello, and starts demultiplexing incoming frames in
+// the background.  Callers must hold connectMu.
+func (d *ReverseDialer) connect(ctx context.Context) error {
+	u, err := parseURLStr(d.coordinatorURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse coordinator URL %q: %v", d.coordinatorURL, err)
+	}
+
+	conn, err := dialURL(ctx, u, d.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to coordinator: %v", err)
+	}
+
+	hello, err := json.Marshal(reverseHello{DialerID: d.dialerID})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to marshal hello: %v", err)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", hello); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send hello: %v", err)
+	}
+
+	d.mu.Lock()
+	d.ctrl = conn
+	d.closed = false
+	d.mu.Unlock()
+
+	go d.demux(conn)
+	return nil
+}
+
+// currentDone returns d's current done channel under d.mu, so callers selecting on it don't race
+// shutdown's reassignment of the field when the tunnel is reconnected.
+func (d *ReverseDialer) currentDone() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.done
+}
+
+// demux reads frames off ctrl until it errors, dispatching each to its stream and delivering
+// freshly opened streams to Accept, until shutdown tears everything down.
+func (d *ReverseDialer) demux(ctrl net.Conn) {
+	reader := bufio.NewReader(ctrl)
+	done := d.currentDone()
+	for {
+		header, payload, err := readReverseFrame(reader)
+		if err != nil {
+			d.shutdown(err)
+			return
+		}
+
+		switch header.typ {
+		case reverseFrameOpen:
+			s := newReverseStream(header.streamID, d.sendFrame, ctrl.LocalAddr(), reverseAddr{network: "reverse", id: header.streamID})
+			d.mu.Lock()
+			d.streams[header.streamID] = s
+			d.mu.Unlock()
+			select {
+			case d.accept <- s:
+			case <-done:
+				return
+			}
+
+		case reverseFrameData:
+			d.mu.Lock()
+			s := d.streams[header.streamID]
+			d.mu.Unlock()
+			if s != nil {
+				s.deliver(payload)
+			}
+
+		case reverseFrameClose:
+			d.mu.Lock()
+			s := d.streams[header.streamID]
+			delete(d.streams, header.streamID)
+			d.mu.Unlock()
+			if s != nil {
+				s.reader.Close()
+			}
+		}
+	}
+}
+
+// sendFrame writes a frame to the control connection, serialized against every reverseStream's
+// concurrent Write calls.
+func (d *ReverseDialer) sendFrame(typ reverseFrameType, streamID uint64, payload []byte) error {
+	d.mu.Lock()
+	ctrl := d.ctrl
+	d.mu.Unlock()
+	if ctrl == nil {
+		return errors.New("reverse dialer not connected")
+	}
+
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	return writeReverseFrame(ctrl, typ, streamID, payload)
+}
+
+// Accept blocks until the coordinator opens a new logical connection, or ctx is done.  Each
+// returned net.Conn is handed to the existing connection machinery exactly like a directly dialed
+// socket.
+func (d *ReverseDialer) Accept(ctx context.Context) (net.Conn, error) {
+	done := d.currentDone()
+	select {
+	case s := <-d.accept:
+		return s, nil
+	case <-done:
+		return nil, errors.New("reverse dialer closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// shutdown tears down every open stream and closes the control connection, after a fatal demux
+// error or an explicit Close.  It is idempotent.
+func (d *ReverseDialer) shutdown(cause error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	ctrl := d.ctrl
+	streams := d.streams
+	d.streams = make(map[uint64]*reverseStream)
+	d.mu.Unlock()
+
+	close(d.done)
+	for _, s := range streams {
+		s.reader.CloseWithError(cause)
+	}
+	if ctrl != nil {
+		ctrl.Close()
+	}
+
+	// A closed tunnel may be reconnected; give the next ensureConnected a fresh done channel to
+	// select on instead of one that's already closed.  The accept channel is never closed, so it
+	// is reused as is.
+	d.mu.Lock()
+	d.done = make(chan struct{})
+	d.mu.Unlock()
+}
+
+// Close tears down the control connection and every stream it is currently multiplexing.
+func (d *ReverseDialer) Close() error {
+	d.shutdown(errors.New("reverse dialer closed"))
+	return nil
+}
+
+// reverseDialerConn is one connected ReverseDialer's control connection, as seen from the
+// coordinator side of a ReverseListener.
+type reverseDialerConn struct {
+	id      string
+	ctrl    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint64]*reverseStream
+}
+
+// send writes a frame to dc's control connection, serialized against concurrent writers.
+func (dc *reverseDialerConn) send(typ reverseFrameType, streamID uint64, payload []byte) error {
+	dc.writeMu.Lock()
+	defer dc.writeMu.Unlock()
+	return writeReverseFrame(dc.ctrl, typ, streamID, payload)
+}
+
+// ReverseListener is the coordinator side of graphd's NAT-traversal reverse-dial tunnel: it
+// accepts inbound control connections from ReverseDialers, and DialerConn opens a new logical
+// connection back through a named dialer's tunnel.  ReverseListener implements net.Listener so it
+// can be handed to any code that expects one (e.g. an RPC server accepting sessions bound for the
+// graphd instance behind the tunnel).
+type ReverseListener struct {
+	ln net.Listener
+
+	nextID atomic.Uint64
+
+	mu      sync.Mutex
+	dialers map[string]*reverseDialerConn
+
+	accept    chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReverseListener wraps ln, accepting ReverseDialer control connections on it in the
+// background.
+func NewReverseListener(ln net.Listener) *ReverseListener {
+	l := &ReverseListener{
+		ln:      ln,
+		dialers: make(map[string]*reverseDialerConn),
+		accept:  make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	go l.acceptControlConns()
+	return l
+}
+
+// acceptControlConns accepts inbound control connections off l.ln until it's closed, handling
+// each one's hello and subsequent frames in its own goroutine.
+func (l *ReverseListener) acceptControlConns() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return
+		}
+		go l.handleControlConn(conn)
+	}
+}
+
+// handleControlConn reads dc's hello, registers it under l.dialers, and then demultiplexes
+// frames off it until it errors or ReverseListener is closed.
+func (l *ReverseListener) handleControlConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	var hello reverseHello
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &hello); err != nil || hello.DialerID == "" {
+		conn.Close()
+		return
+	}
+
+	dc := &reverseDialerConn{id: hello.DialerID, ctrl: conn, streams: make(map[uint64]*reverseStream)}
+	l.mu.Lock()
+	l.dialers[hello.DialerID] = dc
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		if l.dialers[hello.DialerID] == dc {
+			delete(l.dialers, hello.DialerID)
+		}
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		header, payload, err := readReverseFrame(reader)
+		if err != nil {
+			return
+		}
+
+		switch header.typ {
+		case reverseFrameData:
+			dc.mu.Lock()
+			s := dc.streams[header.streamID]
+			dc.mu.Unlock()
+			if s != nil {
+				s.deliver(payload)
+			}
+
+		case reverseFrameClose:
+			dc.mu.Lock()
+			s := dc.streams[header.streamID]
+			delete(dc.streams, header.streamID)
+			dc.mu.Unlock()
+			if s != nil {
+				s.reader.Close()
+			}
+		}
+	}
+}
+
+// DialerConn requests a new logical connection back through the named dialer's tunnel.  It
+// returns an error if no dialer with that ID is currently connected, or if the open frame could
+// not be sent; otherwise the resulting net.Conn is delivered via the next call to Accept, which
+// is how ReverseListener satisfies net.Listener.
+func (l *ReverseListener) DialerConn(dialerID string) error {
+	l.mu.Lock()
+	dc, ok := l.dialers[dialerID]
+	l.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no reverse dialer connected with id %q", dialerID)
+	}
+
+	id := l.nextID.Add(1)
+	s := newReverseStream(id, dc.send, dc.ctrl.LocalAddr(), dc.ctrl.RemoteAddr())
+
+	dc.mu.Lock()
+	dc.streams[id] = s
+	dc.mu.Unlock()
+
+	if err := dc.send(reverseFrameOpen, id, nil); err != nil {
+		return fmt.Errorf("failed to open reverse stream to %q: %v", dialerID, err)
+	}
+
+	select {
+	case l.accept <- s:
+		return nil
+	case <-l.done:
+		return errors.New("reverse listener closed")
+	}
+}
+
+// Accept implements net.Listener, returning the next logical connection opened via DialerConn.
+func (l *ReverseListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("reverse listener closed")
+	}
+}
+
+// Close stops accepting new control connections.  Already-open dialer connections and their
+// streams are torn down as their control connections are closed.
+func (l *ReverseListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+// Addr returns the underlying listener's address.
+func (l *ReverseListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/syslog"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReverseDialerRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	rl := NewReverseListener(ln)
+	defer rl.Close()
+
+	dialer := NewReverseDialer("tcp://"+ln.Addr().String(), "dialer-1")
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := dialer.ensureConnected(ctx); err != nil {
+		t.Fatalf("ensureConnected failed: %v", err)
+	}
+
+	// Give the coordinator a moment to process the hello before requesting a stream.
+	time.Sleep(20 * time.Millisecond)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- rl.DialerConn("dialer-1") }()
+
+	dialerSideConn, err := dialer.Accept(ctx)
+	if err != nil {
+		t.Fatalf("dialer Accept failed: %v", err)
+	}
+	coordSideConn, err := rl.Accept()
+	if err != nil {
+		t.Fatalf("listener Accept failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("DialerConn failed: %v", err)
+	}
+
+	// Coordinator -> dialer.
+	want := "hello from coordinator\n"
+	go fmt.Fprint(coordSideConn, want)
+	got, err := bufio.NewReader(dialerSideConn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dialer-side read failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("dialer-side got %q, want %q", got, want)
+	}
+
+	// Dialer -> coordinator.
+	want = "hello from dialer\n"
+	go fmt.Fprint(dialerSideConn, want)
+	got, err = bufio.NewReader(coordSideConn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("coordinator-side read failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("coordinator-side got %q, want %q", got, want)
+	}
+}
+
+func TestReverseDialerMultipleStreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	rl := NewReverseListener(ln)
+	defer rl.Close()
+
+	dialer := NewReverseDialer("tcp://"+ln.Addr().String(), "dialer-2")
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := dialer.ensureConnected(ctx); err != nil {
+		t.Fatalf("ensureConnected failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	const n = 3
+	for i := 0; i < n; i++ {
+		i := i
+		errCh := make(chan error, 1)
+		go func() { errCh <- rl.DialerConn("dialer-2") }()
+
+		dConn, err := dialer.Accept(ctx)
+		if err != nil {
+			t.Fatalf("stream %d: dialer Accept failed: %v", i, err)
+		}
+		cConn, err := rl.Accept()
+		if err != nil {
+			t.Fatalf("stream %d: listener Accept failed: %v", i, err)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("stream %d: DialerConn failed: %v", i, err)
+		}
+
+		want := fmt.Sprintf("message %d\n", i)
+		go fmt.Fprint(cConn, want)
+		got, err := bufio.NewReader(dConn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream %d: read failed: %v", i, err)
+		}
+		if got != want {
+			t.Errorf("stream %d: got %q, want %q", i, got, want)
+		}
+		dConn.Close()
+		cConn.Close()
+	}
+}
+
+func TestReverseListenerUnknownDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	rl := NewReverseListener(ln)
+	defer rl.Close()
+
+	if err := rl.DialerConn("nonexistent"); err == nil {
+		t.Errorf("DialerConn for an unconnected dialer ID = nil error, want error")
+	}
+}
+
+func TestGraphdWithReverseDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	rl := NewReverseListener(ln)
+	defer rl.Close()
+
+	g := New(testLogger, syslog.LOG_DEBUG, nil, WithReverseDial("tcp://"+ln.Addr().String(), "graphd-1"))
+	defer g.Disconnect()
+
+	dialErrCh := make(chan error, 1)
+	go func() { dialErrCh <- g.Dial(5) }()
+
+	time.Sleep(20 * time.Millisecond)
+	openErrCh := make(chan error, 1)
+	go func() { openErrCh <- rl.DialerConn("graphd-1") }()
+
+	coordConn, err := rl.Accept()
+	if err != nil {
+		t.Fatalf("listener Accept failed: %v", err)
+	}
+	if err := <-openErrCh; err != nil {
+		t.Fatalf("DialerConn failed: %v", err)
+	}
+	if err := <-dialErrCh; err != nil {
+		t.Fatalf("g.Dial failed: %v", err)
+	}
+
+	if got := g.State(); got != StateReady {
+		t.Fatalf("State() = %v, want StateReady", got)
+	}
+
+	// Serve one graphd-style reply over the tunneled connection and confirm Query sees it.
+	go func() {
+		r := bufio.NewReader(coordConn)
+		r.ReadString('\n')
+		fmt.Fprint(coordConn, "ok (1234.56.78)\n")
+	}()
+
+	res, err := g.Query(NewRequest("status ()"))
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(res) != 1 || res[0].Raw() != "ok (1234.56.78)" {
+		t.Errorf("Query result = %v, want ok (1234.56.78)", res)
+	}
+}
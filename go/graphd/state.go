@@ -0,0 +1,65 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The state portion of the graphd package models the lifecycle of a graphd connection.
+
+package graphd
+
+import "context"
+
+// ConnState models the lifecycle of a graphd connection, mirroring gRPC's ClientConn states.
+type ConnState int
+
+const (
+	// StateIdle is a connection's state before any Dial attempt has been made.
+	StateIdle ConnState = iota
+	// StateConnecting is set while a dial attempt, initial or reconnect, is in flight.
+	StateConnecting
+	// StateReady is set once a connection has an established, usable net.Conn.
+	StateReady
+	// StateTransientFailure is set after a dial or write failure.  graphd's background
+	// reconnector (see reconnectLoop) retries with exponential backoff while a connection is in
+	// this state.
+	StateTransientFailure
+	// StateShutdown is set once a connection has been torn down via Disconnect.  A connection in
+	// this state will not be redialed automatically.
+	StateShutdown
+)
+
+// String implements the Stringer interface for a ConnState.
+func (s ConnState) String() string {
+	switch s {
+	case StateIdle:
+		return "IDLE"
+	case StateConnecting:
+		return "CONNECTING"
+	case StateReady:
+		return "READY"
+	case StateTransientFailure:
+		return "TRANSIENT_FAILURE"
+	case StateShutdown:
+		return "SHUTDOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// State returns the current ConnState of g's primary connection.  See PoolStats for the state of
+// every pool member when WithPoolSize configures more than one.
+func (g *graphd) State() ConnState {
+	return g.pool.primary().State()
+}
+
+// WaitForStateChange blocks until g's primary connection's state differs from current, or ctx is
+// done, and reports which happened first: true if the state changed, false if ctx ended the wait.
+func (g *graphd) WaitForStateChange(ctx context.Context, current ConnState) bool {
+	return g.pool.primary().WaitForStateChange(ctx, current)
+}
@@ -4,28 +4,149 @@
 package fakegraphd
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"net"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Exchange pairs a request graphd is expected to receive with the reply it should get back,
+// used by Script to drive a deterministic, ordered conversation.
+type Exchange struct {
+	Request string
+	Reply   string
+}
+
+// ConnStats tracks what has happened on a single connection, so tests can assert on retry and
+// pipelining behaviour.
+type ConnStats struct {
+	Requests int
+	Bytes    int
+}
+
+// respondTo pairs a compiled request pattern with the reply to send when it matches.
+type respondTo struct {
+	re    *regexp.Regexp
+	reply string
+}
+
+// FakeGraphd implements a minimal graphd server for testing clients of the graphd package.  By
+// default it replies to every request with Reply; RespondTo and Script give finer control over
+// what is returned, and the Set* fault-injection knobs let tests exercise the graphd client's
+// failure, cancellation and reconnect paths.
 type FakeGraphd struct {
-	Addr      string
-	Reply     string
-	replyLock sync.RWMutex
+	Addr  string
+	Reply string
+
+	mu         sync.RWMutex
+	responses  []respondTo
+	script     []Exchange
+	scriptErrs []error
+
+	latency       time.Duration
+	dropEveryNth  int
+	truncateBytes int
+	closeAfter    int
+
+	statsMu sync.Mutex
+	conns   map[string]*ConnStats
 }
 
+// New returns a FakeGraphd listening on addr once Start is called.
 func New(addr string) *FakeGraphd {
-	return &FakeGraphd{Addr: addr}
+	return &FakeGraphd{
+		Addr:  addr,
+		conns: make(map[string]*ConnStats),
+	}
 }
 
+// SetReply sets the default reply sent to requests that match neither RespondTo nor Script.
 func (f *FakeGraphd) SetReply(reply string) {
-	f.replyLock.Lock()
-	defer f.replyLock.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.Reply = reply
 }
 
+// RespondTo registers reply as the response to any request matching the regular expression
+// pattern.  Patterns are tried in the order they were registered; the first match wins.  If
+// pattern fails to compile, RespondTo returns an error and does not register the reply.
+func (f *FakeGraphd) RespondTo(pattern string, reply string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("failed to compile pattern %q: %v", pattern, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, respondTo{re, reply})
+	return nil
+}
+
+// Script puts FakeGraphd into scripted mode: each connection's requests are checked in order
+// against exchanges, and the paired reply is returned.  A request that doesn't match the
+// expected exchange is recorded as a mismatch rather than failing the connection, retrievable
+// via Errors.  Script mode takes priority over RespondTo and Reply.
+func (f *FakeGraphd) Script(exchanges []Exchange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = exchanges
+}
+
+// Errors returns the script mismatches recorded since FakeGraphd was created.
+func (f *FakeGraphd) Errors() []error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]error(nil), f.scriptErrs...)
+}
+
+// SetLatency delays every reply by d, to let tests exercise client-side timeouts and deadlines.
+func (f *FakeGraphd) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+// SetDropEveryNth causes FakeGraphd to silently swallow every Nth request (no reply is sent)
+// instead of answering it, simulating a graphd that stalls mid-response.  n <= 0 disables
+// dropping.
+func (f *FakeGraphd) SetDropEveryNth(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropEveryNth = n
+}
+
+// SetTruncateReply caps every reply at bytes bytes, simulating a connection that is cut off
+// mid-response.  bytes <= 0 disables truncation.
+func (f *FakeGraphd) SetTruncateReply(bytes int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.truncateBytes = bytes
+}
+
+// SetCloseAfter causes FakeGraphd to close the connection after n requests have been answered,
+// simulating a graphd that drops the connection after serving a handful of requests.  n <= 0
+// disables this behaviour.
+func (f *FakeGraphd) SetCloseAfter(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeAfter = n
+}
+
+// Stats returns a copy of the per-connection request/byte counters seen so far, keyed by the
+// client's remote address.
+func (f *FakeGraphd) Stats() map[string]ConnStats {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	stats := make(map[string]ConnStats, len(f.conns))
+	for addr, s := range f.conns {
+		stats[addr] = *s
+	}
+	return stats
+}
+
 func (f *FakeGraphd) Start() (func() error, error) {
 	ln, err := net.Listen("tcp", f.Addr)
 	if err != nil {
@@ -37,6 +158,7 @@ func (f *FakeGraphd) Start() (func() error, error) {
 			conn, err := ln.Accept()
 			if err != nil {
 				log.Printf("UH OH: %v", err)
+				return
 			}
 			log.Printf("Handle: %v", conn)
 			go f.handle(conn)
@@ -45,8 +167,83 @@ func (f *FakeGraphd) Start() (func() error, error) {
 	return ln.Close, nil
 }
 
+// handle reads newline-terminated graphd requests off c, one at a time, and writes back
+// whatever reply RespondTo/Script/Reply dictates -- subject to the Set* fault-injection knobs --
+// until the connection is closed by the client or by a SetCloseAfter limit.
 func (f *FakeGraphd) handle(c net.Conn) {
-	f.replyLock.RLock()
-	defer f.replyLock.RUnlock()
-	fmt.Fprintf(c, f.Reply)
+	defer c.Close()
+
+	remote := c.RemoteAddr().String()
+	f.statsMu.Lock()
+	f.conns[remote] = &ConnStats{}
+	f.statsMu.Unlock()
+
+	reader := bufio.NewReader(c)
+	scriptIdx := 0
+
+	for {
+		line, readErr := reader.ReadString('\n')
+		if len(line) == 0 && readErr != nil {
+			return
+		}
+
+		f.statsMu.Lock()
+		stats := f.conns[remote]
+		stats.Requests++
+		stats.Bytes += len(line)
+		reqNum := stats.Requests
+		f.statsMu.Unlock()
+
+		reply := f.replyFor(line, scriptIdx)
+		scriptIdx++
+
+		f.mu.RLock()
+		dropEveryNth, latency, truncateBytes, closeAfter := f.dropEveryNth, f.latency, f.truncateBytes, f.closeAfter
+		f.mu.RUnlock()
+
+		dropped := dropEveryNth > 0 && reqNum%dropEveryNth == 0
+		if !dropped {
+			if latency > 0 {
+				time.Sleep(latency)
+			}
+			if truncateBytes > 0 && len(reply) > truncateBytes {
+				reply = reply[:truncateBytes]
+			}
+			fmt.Fprint(c, reply)
+		}
+
+		if readErr != nil || (closeAfter > 0 && reqNum >= closeAfter) {
+			return
+		}
+	}
+}
+
+// replyFor picks the reply for req, consulting Script mode first, then RespondTo, then falling
+// back to the default Reply.  Mismatches in Script mode are recorded in scriptErrs rather than
+// failing the connection, since handle has no *testing.T to report them to.
+func (f *FakeGraphd) replyFor(req string, scriptIdx int) string {
+	trimmed := strings.TrimSpace(req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.script) > 0 {
+		if scriptIdx >= len(f.script) {
+			f.scriptErrs = append(f.scriptErrs, fmt.Errorf("unexpected request %d %q: script exhausted", scriptIdx, trimmed))
+			return f.Reply
+		}
+		want := f.script[scriptIdx]
+		if strings.TrimSpace(want.Request) != trimmed {
+			f.scriptErrs = append(f.scriptErrs, fmt.Errorf("request %d: got %q, want %q", scriptIdx, trimmed, want.Request))
+		}
+		return want.Reply
+	}
+
+	for _, r := range f.responses {
+		if r.re.MatchString(trimmed) {
+			return r.reply
+		}
+	}
+
+	return f.Reply
 }
@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 const addr = ":8081"
@@ -31,6 +32,116 @@ func TestFakeReply(t *testing.T) {
 	}
 }
 
+func TestRespondTo(t *testing.T) {
+	fg.SetReply("ok ()\n")
+	if err := fg.RespondTo(`^write `, "error (SEMANTICS) \"write not allowed\"\n"); err != nil {
+		t.Fatalf("Unexpected error registering pattern: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to fakegraphd: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "write (foo)\n")
+	got, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Unexpected error reading response: %v", err)
+	}
+	want := "error (SEMANTICS) \"write not allowed\"\n"
+	if got != want {
+		t.Errorf("Unexpected reply for matched pattern, got = %v, want = %v", got, want)
+	}
+
+	fmt.Fprintf(conn, "status ()\n")
+	got, err = r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Unexpected error reading response: %v", err)
+	}
+	if got != "ok ()\n" {
+		t.Errorf("Unexpected reply for unmatched request, got = %v, want = ok ()", got)
+	}
+}
+
+func TestScript(t *testing.T) {
+	fg.Script([]Exchange{
+		{Request: "status ()", Reply: "ok (1)\n"},
+		{Request: "status ()", Reply: "ok (2)\n"},
+	})
+	defer fg.Script(nil)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to fakegraphd: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, want := range []string{"ok (1)\n", "ok (2)\n"} {
+		fmt.Fprintf(conn, "status ()\n")
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Unexpected error reading response: %v", err)
+		}
+		if got != want {
+			t.Errorf("Unexpected scripted reply, got = %v, want = %v", got, want)
+		}
+	}
+	if errs := fg.Errors(); len(errs) != 0 {
+		t.Errorf("Unexpected script mismatches: %v", errs)
+	}
+}
+
+func TestCloseAfter(t *testing.T) {
+	fg.SetReply("ok ()\n")
+	fg.SetCloseAfter(1)
+	defer fg.SetCloseAfter(0)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to fakegraphd: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "status ()\n")
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Fatalf("Unexpected error reading first response: %v", err)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Errorf("Expected connection to be closed after 1 request, but read succeeded")
+	}
+}
+
+func TestStats(t *testing.T) {
+	fg.SetReply("ok ()\n")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to fakegraphd: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "status ()\n")
+	r := bufio.NewReader(conn)
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Fatalf("Unexpected error reading response: %v", err)
+	}
+
+	// Give the server goroutine a moment to record stats before reading them back.
+	time.Sleep(10 * time.Millisecond)
+
+	stats, ok := fg.Stats()[conn.LocalAddr().String()]
+	if !ok {
+		t.Fatalf("No stats recorded for %v", conn.LocalAddr())
+	}
+	if stats.Requests != 1 {
+		t.Errorf("Unexpected request count, got = %v, want = 1", stats.Requests)
+	}
+}
+
 func TestMain(m *testing.M) {
 	fg = New(addr)
 	cleanup, err := fg.Start()
@@ -0,0 +1,156 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCA writes a freshly generated self-signed CA certificate (PEM-encoded) to a file under
+// t.TempDir and returns its path.
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-ca"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		t.Fatalf("failed to write ca file: %v", err)
+	}
+	return path
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", rawURL, err)
+	}
+	return u
+}
+
+func TestTLSConfigForURLClonesBase(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS13}
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100")
+
+	cfg, err := tlsConfigForURL(u, base)
+	if err != nil {
+		t.Fatalf("tlsConfigForURL failed: %v", err)
+	}
+	if cfg.MinVersion != base.MinVersion {
+		t.Errorf("MinVersion = %v, want base's %v", cfg.MinVersion, base.MinVersion)
+	}
+
+	// Mutating the returned config must not reach back into base: tlsConfigForURL is documented
+	// to clone it, not share it, since the same base is reused to dial every URL.
+	cfg.MinVersion = tls.VersionTLS11
+	if base.MinVersion != tls.VersionTLS13 {
+		t.Errorf("base.MinVersion = %v after mutating the clone, want unchanged %v", base.MinVersion, tls.VersionTLS13)
+	}
+}
+
+func TestTLSConfigForURLDefaultsServerNameToHostname(t *testing.T) {
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100")
+
+	cfg, err := tlsConfigForURL(u, nil)
+	if err != nil {
+		t.Fatalf("tlsConfigForURL failed: %v", err)
+	}
+	if cfg.ServerName != "db.example.com" {
+		t.Errorf("ServerName = %q, want %q (from u.Hostname())", cfg.ServerName, "db.example.com")
+	}
+}
+
+func TestTLSConfigForURLServerNameOverride(t *testing.T) {
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100?servername=override.example.com")
+
+	cfg, err := tlsConfigForURL(u, nil)
+	if err != nil {
+		t.Fatalf("tlsConfigForURL failed: %v", err)
+	}
+	if cfg.ServerName != "override.example.com" {
+		t.Errorf("ServerName = %q, want %q", cfg.ServerName, "override.example.com")
+	}
+}
+
+func TestTLSConfigForURLInsecureSkipVerify(t *testing.T) {
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100?insecureSkipVerify=1")
+
+	cfg, err := tlsConfigForURL(u, nil)
+	if err != nil {
+		t.Fatalf("tlsConfigForURL failed: %v", err)
+	}
+	if !cfg.InsecureSkipVerify {
+		t.Error("InsecureSkipVerify = false, want true")
+	}
+}
+
+func TestTLSConfigForURLLoadsCA(t *testing.T) {
+	caPath := writeTestCA(t)
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100?ca="+caPath)
+
+	cfg, err := tlsConfigForURL(u, nil)
+	if err != nil {
+		t.Fatalf("tlsConfigForURL failed: %v", err)
+	}
+	if cfg.RootCAs == nil {
+		t.Fatal("RootCAs = nil, want the pool loaded from ca=")
+	}
+	if n := len(cfg.RootCAs.Subjects()); n != 1 {
+		t.Errorf("RootCAs has %d subjects, want 1", n)
+	}
+}
+
+func TestTLSConfigForURLCAFileUnreadable(t *testing.T) {
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100?ca="+filepath.Join(t.TempDir(), "missing.pem"))
+
+	if _, err := tlsConfigForURL(u, nil); err == nil {
+		t.Fatal("tlsConfigForURL with an unreadable ca file returned nil error, want one")
+	}
+}
+
+func TestTLSConfigForURLCAFileUnparsable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "garbage.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write garbage ca file: %v", err)
+	}
+	u := mustParseURL(t, "graphd+tls://db.example.com:8100?ca="+path)
+
+	if _, err := tlsConfigForURL(u, nil); err == nil {
+		t.Fatal("tlsConfigForURL with an unparsable ca file returned nil error, want one")
+	}
+}
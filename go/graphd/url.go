@@ -21,8 +21,13 @@ import (
 
 // Supported URL schemes over which to connect.
 const (
-	TCP_SCHEME  = "tcp"
-	UNIX_SCHEME = "unix"
+	TCP_SCHEME      = "tcp"
+	UNIX_SCHEME     = "unix"
+	TLS_SCHEME      = "tls"
+	UNIX_TLS_SCHEME = "unix+tls"
+	// GRAPHD_TLS_SCHEME is an alias for TLS_SCHEME, read more naturally in a graphd URL list than
+	// the bare "tls" scheme borrowed from database/sql-style DSNs.
+	GRAPHD_TLS_SCHEME = "graphd+tls"
 )
 
 // Default URL to which to connect.
@@ -36,13 +41,47 @@ const (
 // isURLSchemeSupported checks the URL scheme against supported schemes and returns true or false.
 func isURLSchemeSupported(scheme string) bool {
 	switch scheme {
-	case TCP_SCHEME, UNIX_SCHEME:
+	case TCP_SCHEME, UNIX_SCHEME, TLS_SCHEME, UNIX_TLS_SCHEME, GRAPHD_TLS_SCHEME:
 		return true
 	default:
 		return false
 	}
 }
 
+// isTLSScheme reports whether scheme dials over TLS.
+func isTLSScheme(scheme string) bool {
+	switch scheme {
+	case TLS_SCHEME, UNIX_TLS_SCHEME, GRAPHD_TLS_SCHEME:
+		return true
+	default:
+		return false
+	}
+}
+
+// networkForScheme maps a URL scheme to the net.Dial/net.Dialer network name used to actually
+// reach it, stripping the "+tls" (or "tls" equivalent) layer that dial handles separately via
+// crypto/tls.
+func networkForScheme(scheme string) string {
+	switch scheme {
+	case TLS_SCHEME, GRAPHD_TLS_SCHEME:
+		return TCP_SCHEME
+	case UNIX_TLS_SCHEME:
+		return UNIX_SCHEME
+	default:
+		return scheme
+	}
+}
+
+// addrForURL returns the dial address to use for u: its Path for a unix-network scheme with a
+// non-empty path (e.g. "unix:///var/run/graphd.sock"), falling back to Host (e.g. for
+// "unix://graphd.sock", where url.Parse treats "graphd.sock" as the host).
+func addrForURL(u *url.URL) string {
+	if networkForScheme(u.Scheme) == UNIX_SCHEME && u.Path != "" {
+		return u.Path
+	}
+	return u.Host
+}
+
 // parseURLStr takes a URL string of the form
 // "scheme://hostname|ipv4_address|[ipv6_address]:port" (where 'port' may either be a port number or
 // service name, and returns the url.URL pointer and error obtained from url.Parse.  If the URL scheme